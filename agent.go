@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"lucidsearch/quota"
+	"lucidsearch/rewrite"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hops an agentic search makes unless the config says otherwise.
+const defaultMaxHops = 3
+
+// Step is one hop of an agentic search: a sub-question researched with its
+// own search, scrape, embed and retrieve round.
+type Step struct {
+	Hop int `json:"hop"`
+	// Question is the sub-question as planned, and Searched the same
+	// question made self-contained with earlier findings, when it differs.
+	Question  string            `json:"question"`
+	Searched  string            `json:"searched,omitempty"`
+	Queries   []rewrite.Rewrite `json:"queries"`
+	Sources   int               `json:"sources"`
+	Citations []int             `json:"citations"`
+	Answer    string            `json:"answer"`
+	Error     string            `json:"error,omitempty"`
+	Duration  string            `json:"duration"`
+}
+
+// Trace records how an agentic search answered a question.
+type Trace struct {
+	SubQuestions []string `json:"sub_questions"`
+	Steps        []Step   `json:"steps"`
+}
+
+const stepInstruction = `You are researching one part of a larger question. Answer only the sub-question below, in a few sentences, using only the context provided, and cite the citation number given with each paragraph, like this [1], after each fact. If the context does not answer it, say what is missing.`
+
+const agentInstruction = ` The question was researched in steps; the findings of each step are given below with their citations, followed by all the paragraphs they were drawn from. Combine them into one answer to the question, comparing or connecting the findings where the question asks for it, and keep the citation numbers.`
+
+// research answers a complex question in up to maxHops rounds: the question
+// is split into sub-questions, each is searched and answered in turn from
+// its own retrieval, and the final answer is written from all findings,
+// citing across rounds.
+func (p *pipeline) research(question string, maxHops int, rewriteQuery bool) (string, []Citation, Trace, error) {
+	gen := p.generator()
+	subQuestions, err := rewrite.Decompose(p.ctx, gen, question, maxHops)
+	if err != nil {
+		log.Printf("Researching the question in one step: %v", err)
+	}
+	trace := Trace{SubQuestions: subQuestions}
+
+	citations := newCitationContext(p.profile)
+	findings := ""
+	var lastErr error
+	for i, subQuestion := range subQuestions {
+		started := time.Now()
+		step := Step{Hop: i + 1, Question: subQuestion}
+		searched := subQuestion
+		if findings != "" {
+			resolved, err := rewrite.Resolve(p.ctx, gen, subQuestion, findings)
+			if err != nil {
+				log.Printf("Searching the sub-question as planned: %v", err)
+			} else if resolved != subQuestion {
+				searched = resolved
+				step.Searched = resolved
+			}
+		}
+		log.Printf("Hop %d: %s", step.Hop, searched)
+
+		plan := p.plan(searched, rewriteQuery)
+		step.Queries = plan.Queries
+		step.Sources = p.gather(plan.Queries)
+
+		chunks, err := p.retrieve(searched, plan.Hypothetical)
+		if err == nil {
+			context, _, numbers := citations.add(chunks)
+			step.Citations = uniqueNumbers(numbers)
+			if len(chunks) == 0 {
+				step.Answer = "Nothing relevant was found."
+			} else {
+				prompt := "INSTRUCTION : " + stepInstruction + " MAIN QUESTION : " + question + " . FINDINGS SO FAR : " + findings + " SUB-QUESTION : " + searched + " . CONTEXT : " + context + "."
+				step.Answer, err = gen.Generate(p.ctx, prompt, 0)
+			}
+		}
+		if err != nil {
+			lastErr = err
+			step.Error = err.Error()
+		} else {
+			findings += "Step " + strconv.Itoa(step.Hop) + " (" + searched + ") : " + step.Answer + "\n"
+		}
+		step.Duration = time.Since(started).Round(time.Millisecond).String()
+		trace.Steps = append(trace.Steps, step)
+		if errors.Is(err, quota.ErrExhausted) {
+			// Further hops would fail the same way.
+			break
+		}
+	}
+
+	if len(citations.chunks) == 0 && lastErr != nil {
+		return "", nil, trace, lastErr
+	}
+	context, passages := citations.all()
+	prompt := "INSTRUCTION : " + citations.instruction() + agentInstruction + " QUERY : " + question + " . FINDINGS : " + findings + " CONTEXT : " + context + "."
+	answer, cited := citations.answer(p.ctx, gen, prompt, passages)
+	return answer, cited, trace, nil
+}
+
+func uniqueNumbers(numbers []int) []int {
+	seen := map[int]bool{}
+	var unique []int
+	for _, n := range numbers {
+		if !seen[n] {
+			seen[n] = true
+			unique = append(unique, n)
+		}
+	}
+	return unique
+}
+
+func formatTrace(trace Trace) string {
+	var sb strings.Builder
+	sb.WriteString("Research steps :\n")
+	for _, step := range trace.Steps {
+		question := step.Question
+		if step.Searched != "" {
+			question += " -> " + step.Searched
+		}
+		sb.WriteString(fmt.Sprintf("[%d] %s (%d new sources, cites %v, %s)\n", step.Hop, question, step.Sources, step.Citations, step.Duration))
+		if step.Error != "" {
+			sb.WriteString("    error: " + step.Error + "\n")
+		}
+		if step.Answer != "" {
+			sb.WriteString("    " + strings.ReplaceAll(strings.TrimSpace(step.Answer), "\n", "\n    ") + "\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,135 @@
+package caselaw
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReadBulk streams the opinions of a CourtListener bulk download to fn. path
+// is a JSON file, a directory of them, or a .tar or .tar.gz archive of them,
+// as the per-court bulk files are. A file holds one opinion, an array of
+// them, or one per line. Opinions without text or a link to cite are
+// skipped. ReadBulk stops at the first error fn returns.
+func ReadBulk(path, site string, fn func(Opinion) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !strings.HasSuffix(name, ".json") {
+				return err
+			}
+			return readFile(name, site, fn)
+		})
+	}
+	if strings.HasSuffix(path, ".tar") || strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz") {
+		return readArchive(path, site, fn)
+	}
+	return readFile(path, site, fn)
+}
+
+func readFile(path, site string, fn func(Opinion) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if err := readJSON(r, site, fn); err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return nil
+}
+
+func readArchive(path, site string, fn func(Opinion) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	if !strings.HasSuffix(path, ".tar") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", path, err)
+		}
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(header.Name, ".json") {
+			continue
+		}
+		if err := readJSON(archive, site, fn); err != nil {
+			return fmt.Errorf("error reading %s in %s: %v", header.Name, path, err)
+		}
+	}
+}
+
+// readJSON passes on every opinion in a stream of JSON values, each an
+// opinion or an array of them.
+func readJSON(r io.Reader, site string, fn func(Opinion) error) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		values := []json.RawMessage{value}
+		if len(value) > 0 && value[0] == '[' {
+			values = nil
+			if err := json.Unmarshal(value, &values); err != nil {
+				return err
+			}
+		}
+		for _, value := range values {
+			opinion, err := ParseOpinion(value, site)
+			if err != nil {
+				return err
+			}
+			if opinion.Text == "" || opinion.Link == "" {
+				continue
+			}
+			// The opinions of a case share its link; tell them apart so a
+			// dissent doesn't replace the majority opinion in the store.
+			if opinion.ID != 0 {
+				opinion.Link += "#o" + strconv.FormatInt(opinion.ID, 10)
+			}
+			if err := fn(opinion); err != nil {
+				return err
+			}
+		}
+	}
+}
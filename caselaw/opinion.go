@@ -0,0 +1,288 @@
+package caselaw
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"lucidsearch/embedstore"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Opinion is a court opinion with the details needed to cite it.
+type Opinion struct {
+	ID            int64
+	CaseName      string
+	Court         string // court name or ID, e.g. scotus
+	CourtCitation string // the court as cited, e.g. "9th Cir."
+	DocketNumber  string
+	DateFiled     string
+	Citations     []string // reporter citations, e.g. "576 U.S. 644"
+	Type          string   // lead opinion, concurrence, dissent, ...
+	Author        string
+	Link          string
+	Text          string
+	SubOpinionIDs []int64 // the opinions of a search hit, which is a cluster
+	AbsoluteURL   string
+}
+
+// record holds the fields of the CourtListener JSON shapes opinions come in:
+// search hits (camelCase), and opinions and clusters from the REST API and
+// bulk data (snake_case), whose cluster and docket may be nested objects.
+type record struct {
+	ID                int64     `json:"id"`
+	AbsoluteURL       string    `json:"absolute_url"`
+	CaseName          string    `json:"case_name"`
+	CaseNameCamel     string    `json:"caseName"`
+	Court             string    `json:"court"`
+	CourtID           string    `json:"court_id"`
+	CourtCitation     string    `json:"court_citation_string"`
+	DocketNumber      string    `json:"docket_number"`
+	DocketNumberCamel string    `json:"docketNumber"`
+	DateFiled         string    `json:"date_filed"`
+	DateFiledCamel    string    `json:"dateFiled"`
+	Citations         citations `json:"citations"`
+	Citation          citations `json:"citation"`
+	Type              string    `json:"type"`
+	AuthorStr         string    `json:"author_str"`
+	PlainText         string    `json:"plain_text"`
+	HTMLWithCitations string    `json:"html_with_citations"`
+	HTML              string    `json:"html"`
+	HTMLLawbox        string    `json:"html_lawbox"`
+	HTMLColumbia      string    `json:"html_columbia"`
+	XMLHarvard        string    `json:"xml_harvard"`
+	Snippet           string    `json:"snippet"`
+	Cluster           nested    `json:"cluster"`
+	Docket            nested    `json:"docket"`
+	Opinions          []struct {
+		ID      int64  `json:"id"`
+		Snippet string `json:"snippet"`
+	} `json:"opinions"`
+}
+
+// nested is a related object, which the API gives either inline or as a
+// link to fetch it from. Links are ignored.
+type nested struct {
+	*record
+}
+
+func (n *nested) UnmarshalJSON(data []byte) error {
+	if len(data) == 0 || data[0] != '{' {
+		return nil
+	}
+	n.record = &record{}
+	return json.Unmarshal(data, n.record)
+}
+
+// citations are either strings or {volume, reporter, page} objects.
+type citations []string
+
+func (c *citations) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		// A lone string, as some exports have.
+		var s string
+		if json.Unmarshal(data, &s) == nil && s != "" {
+			*c = citations{s}
+		}
+		return nil
+	}
+	for _, item := range raw {
+		var s string
+		if json.Unmarshal(item, &s) == nil {
+			*c = append(*c, s)
+			continue
+		}
+		var cite struct {
+			Volume   json.Number `json:"volume"`
+			Reporter string      `json:"reporter"`
+			Page     string      `json:"page"`
+		}
+		if err := json.Unmarshal(item, &cite); err != nil {
+			return err
+		}
+		if cite.Reporter != "" {
+			*c = append(*c, strings.TrimSpace(fmt.Sprintf("%s %s %s", cite.Volume, cite.Reporter, cite.Page)))
+		}
+	}
+	return nil
+}
+
+// Opinion type codes used by CourtListener.
+var opinionTypes = map[string]string{
+	"010combined":          "Combined Opinion",
+	"015unamimous":         "Unanimous Opinion",
+	"020lead":              "Lead Opinion",
+	"025plurality":         "Plurality Opinion",
+	"030concurrence":       "Concurrence",
+	"035concurrenceinpart": "Concurrence in Part",
+	"040dissent":           "Dissent",
+	"050addendum":          "Addendum",
+	"060remittitur":        "Remittitur",
+	"070rehearing":         "Rehearing",
+	"080onthemerits":       "On the Merits",
+	"090onmotiontostrike":  "On Motion to Strike",
+}
+
+// ParseOpinion reads an opinion, a cluster or a search hit in any of the
+// CourtListener JSON shapes. site is prepended to relative links.
+func ParseOpinion(data []byte, site string) (Opinion, error) {
+	var r record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Opinion{}, fmt.Errorf("error parsing opinion: %v", err)
+	}
+	return r.opinion(site), nil
+}
+
+func (r *record) opinion(site string) Opinion {
+	o := Opinion{
+		ID:            r.ID,
+		CaseName:      first(r.CaseName, r.CaseNameCamel),
+		Court:         first(r.Court, r.CourtID),
+		CourtCitation: r.CourtCitation,
+		DocketNumber:  first(r.DocketNumber, r.DocketNumberCamel),
+		DateFiled:     first(r.DateFiled, r.DateFiledCamel),
+		Citations:     append(r.Citations, r.Citation...),
+		Type:          opinionTypes[r.Type],
+		Author:        r.AuthorStr,
+		AbsoluteURL:   r.AbsoluteURL,
+	}
+	if o.Type == "" {
+		o.Type = r.Type
+	}
+	// The REST API links the court rather than naming it.
+	if strings.HasPrefix(o.Court, "http") {
+		o.Court = lastPathSegment(o.Court)
+	}
+
+	switch {
+	case r.PlainText != "":
+		o.Text = normalize(r.PlainText)
+	case r.HTMLWithCitations != "":
+		o.Text = htmlText(r.HTMLWithCitations)
+	case r.HTMLLawbox != "":
+		o.Text = htmlText(r.HTMLLawbox)
+	case r.HTMLColumbia != "":
+		o.Text = htmlText(r.HTMLColumbia)
+	case r.HTML != "":
+		o.Text = htmlText(r.HTML)
+	case r.XMLHarvard != "":
+		o.Text = htmlText(r.XMLHarvard)
+	}
+
+	var snippets []string
+	for _, sub := range r.Opinions {
+		o.SubOpinionIDs = append(o.SubOpinionIDs, sub.ID)
+		if sub.Snippet != "" {
+			snippets = append(snippets, htmlText(sub.Snippet))
+		}
+	}
+	if r.Snippet != "" {
+		snippets = append(snippets, htmlText(r.Snippet))
+	}
+	if o.Text == "" {
+		o.Text = strings.Join(snippets, "\n\n")
+	}
+	if r.Cluster.record != nil {
+		o.Fill(r.Cluster.opinion(site))
+	}
+	if r.Docket.record != nil {
+		o.Fill(r.Docket.opinion(site))
+	}
+
+	if o.AbsoluteURL != "" {
+		o.Link = strings.TrimRight(site, "/") + o.AbsoluteURL
+	}
+	return o
+}
+
+// Fill copies the case details o lacks from the cluster or search hit it
+// belongs to.
+func (o *Opinion) Fill(other Opinion) {
+	o.CaseName = first(o.CaseName, other.CaseName)
+	o.Court = first(o.Court, other.Court)
+	o.CourtCitation = first(o.CourtCitation, other.CourtCitation)
+	o.DocketNumber = first(o.DocketNumber, other.DocketNumber)
+	o.DateFiled = first(o.DateFiled, other.DateFiled)
+	o.AbsoluteURL = first(o.AbsoluteURL, other.AbsoluteURL)
+	o.Link = first(o.Link, other.Link)
+	if len(o.Citations) == 0 {
+		o.Citations = other.Citations
+	}
+}
+
+// Metadata is the payload stored with the opinion's chunks.
+func (o Opinion) Metadata(source string) map[string]string {
+	metadata := map[string]string{
+		"source":        source,
+		"title":         o.CaseName,
+		"court":         o.Court,
+		"docket_number": o.DocketNumber,
+		"decided":       o.DateFiled,
+		"citation":      strings.Join(o.Citations, "; "),
+		"opinion_type":  o.Type,
+		"byline":        o.Author,
+	}
+	if o.CourtCitation != "" {
+		metadata["court_citation"] = o.CourtCitation
+	}
+	if o.ID != 0 {
+		metadata["opinion_id"] = strconv.FormatInt(o.ID, 10)
+	}
+	for key, value := range metadata {
+		if value == "" {
+			delete(metadata, key)
+		}
+	}
+	return metadata
+}
+
+// Result turns an opinion into a search result whose document is the
+// opinion's text.
+func Result(o Opinion, source string) embedstore.Result {
+	result := embedstore.Result{Title: o.CaseName, Link: o.Link}
+	if o.Text != "" {
+		result.Documents = []embedstore.Document{{PageContent: o.Text, Metadata: o.Metadata(source)}}
+	}
+	return result
+}
+
+var (
+	blockTag = regexp.MustCompile(`(?i)</?(p|div|br|blockquote|h[1-6]|li|tr|pre)[^>]*>`)
+	anyTag   = regexp.MustCompile(`<[^>]+>`)
+	blanks   = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// htmlText turns opinion HTML into paragraphs of plain text.
+func htmlText(s string) string {
+	s = blockTag.ReplaceAllString(s, "\n\n")
+	s = anyTag.ReplaceAllString(s, "")
+	return normalize(html.UnescapeString(s))
+}
+
+// normalize collapses whitespace within paragraphs, keeping the paragraph
+// breaks.
+func normalize(s string) string {
+	var paragraphs []string
+	for _, paragraph := range blanks.Split(s, -1) {
+		if paragraph = strings.Join(strings.Fields(paragraph), " "); paragraph != "" {
+			paragraphs = append(paragraphs, paragraph)
+		}
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func first(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func lastPathSegment(link string) string {
+	parts := strings.Split(strings.Trim(link, "/"), "/")
+	return parts[len(parts)-1]
+}
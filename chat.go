@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"lucidsearch/profile"
+	"lucidsearch/quota"
+	"lucidsearch/rewrite"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// The OpenAI chat completions API, answered by the search pipeline, so OpenAI
+// clients can be used as search clients. The model names a search profile.
+
+type chatMessage struct {
+	Role string `json:"role"`
+	// Content is a string, or a list of parts of which the text ones are
+	// read.
+	Content json.RawMessage `json:"content"`
+}
+
+func (m chatMessage) text() string {
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	json.Unmarshal(m.Content, &parts)
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+}
+
+// chatExtension is added to responses as "lucidsearch": the citations the
+// answer's [n] markers refer to, the sources checked, and the question as it
+// was searched when it was a follow-up.
+type chatExtension struct {
+	Citations  []Citation     `json:"citations"`
+	Sources    []SourceStatus `json:"sources"`
+	Standalone string         `json:"standalone,omitempty"`
+}
+
+type chatCompletion struct {
+	ID          string         `json:"id"`
+	Object      string         `json:"object"`
+	Created     int64          `json:"created"`
+	Model       string         `json:"model"`
+	Choices     []chatChoice   `json:"choices"`
+	LucidSearch *chatExtension `json:"lucidsearch,omitempty"`
+}
+
+type chatChoice struct {
+	Index        int              `json:"index"`
+	Message      *responseMessage `json:"message,omitempty"`
+	Delta        *responseMessage `json:"delta,omitempty"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+type responseMessage struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+func chatError(w http.ResponseWriter, status int, message, kind string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"message": message, "type": kind}})
+}
+
+// chatCompletions answers the last user message of a chat. Earlier messages
+// are the conversation it is condensed from; system messages are ignored.
+// Requests need CHAT_API_KEY as their bearer token, unless auth is disabled.
+func chatCompletions(profiles map[string]*profile.Profile, authDisabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			chatError(w, http.StatusMethodNotAllowed, "Use POST", "invalid_request_error")
+			return
+		}
+		if !authorized(r, "CHAT_API_KEY", authDisabled) {
+			chatError(w, http.StatusUnauthorized, "Invalid API key: set CHAT_API_KEY and send it as the API key", "invalid_request_error")
+			return
+		}
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			chatError(w, http.StatusBadRequest, "Invalid request: "+err.Error(), "invalid_request_error")
+			return
+		}
+
+		last := -1
+		for i, message := range req.Messages {
+			if message.Role == "user" {
+				last = i
+			}
+		}
+		if last < 0 || strings.TrimSpace(req.Messages[last].text()) == "" {
+			chatError(w, http.StatusBadRequest, "A user message must be provided", "invalid_request_error")
+			return
+		}
+		query := req.Messages[last].text()
+		var history strings.Builder
+		for _, message := range req.Messages[:last] {
+			switch message.Role {
+			case "user":
+				history.WriteString("USER : " + message.text() + "\n")
+			case "assistant":
+				history.WriteString("ASSISTANT : " + message.text() + "\n")
+			}
+		}
+
+		// Clients send whatever model they are set up with; unless it
+		// names a profile, the default one answers.
+		searchProfile, ok := profiles[req.Model]
+		if !ok {
+			searchProfile = profiles[profile.Default]
+		}
+
+		// A client hanging up stops the search, and the quota it would use.
+		ctx := r.Context()
+		client, err := genai.NewClient(ctx, option.WithAPIKey(g_Api_Key))
+		if err != nil {
+			log.Printf("Error creating Gemini client: %v", err)
+			chatError(w, http.StatusInternalServerError, "Search failed", "server_error")
+			return
+		}
+		defer client.Close()
+
+		pipe := newPipeline(ctx, client, searchProfile)
+		standalone := query
+		if history.Len() > 0 {
+			standalone, err = rewrite.Condense(ctx, pipe.generator(), history.String(), query)
+			if err != nil {
+				log.Printf("Searching the follow-up as asked: %v", err)
+			}
+			log.Printf("Standalone question: %s", standalone)
+		}
+
+		completion := chatCompletion{
+			ID:      "chatcmpl-" + randomID(),
+			Created: time.Now().Unix(),
+			Model:   req.Model,
+		}
+		stop := "stop"
+		if !req.Stream {
+			answer, cited, _, err := pipe.ask(standalone, searchProfile.Rewrite, nil)
+			if errors.Is(err, quota.ErrExhausted) {
+				chatError(w, http.StatusServiceUnavailable, "The daily embedding budget is used up, try again tomorrow", "quota_exceeded")
+				return
+			}
+			if err != nil {
+				log.Printf("Search failed: %v", err)
+				chatError(w, http.StatusInternalServerError, "Search failed", "server_error")
+				return
+			}
+			completion.Object = "chat.completion"
+			completion.Choices = []chatChoice{{
+				Message:      &responseMessage{Role: "assistant", Content: strings.TrimSuffix(answer, "\n---\n")},
+				FinishReason: &stop,
+			}}
+			completion.LucidSearch = newChatExtension(cited, pipe.sources, standalone, query)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(completion)
+			return
+		}
+
+		// Streamed as server-sent events. The role goes out first, so
+		// clients know the request is being worked on while it is
+		// searched.
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		flusher, _ := w.(http.Flusher)
+		completion.Object = "chat.completion.chunk"
+		send := func(delta responseMessage, finish *string, extension *chatExtension) {
+			chunk := completion
+			chunk.Choices = []chatChoice{{Delta: &delta, FinishReason: finish}}
+			chunk.LucidSearch = extension
+			data, _ := json.Marshal(chunk)
+			w.Write([]byte("data: " + string(data) + "\n\n"))
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		send(responseMessage{Role: "assistant"}, nil, nil)
+
+		_, cited, _, err := pipe.ask(standalone, searchProfile.Rewrite, func(text string) {
+			send(responseMessage{Content: text}, nil, nil)
+		})
+		if errors.Is(err, quota.ErrExhausted) {
+			send(responseMessage{Content: "The daily embedding budget is used up, try again tomorrow"}, nil, nil)
+		} else if err != nil {
+			log.Printf("Search failed: %v", err)
+			send(responseMessage{Content: answerFailed}, nil, nil)
+		}
+		send(responseMessage{}, &stop, newChatExtension(cited, pipe.sources, standalone, query))
+		w.Write([]byte("data: [DONE]\n\n"))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func newChatExtension(cited []Citation, sources []SourceStatus, standalone, query string) *chatExtension {
+	extension := &chatExtension{Citations: cited, Sources: sources}
+	if extension.Citations == nil {
+		extension.Citations = []Citation{}
+	}
+	if standalone != query {
+		extension.Standalone = standalone
+	}
+	return extension
+}
+
+// chatModels lists the profiles as the models chat completions accept.
+func chatModels(profiles map[string]*profile.Profile) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var names []string
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		type model struct {
+			ID      string `json:"id"`
+			Object  string `json:"object"`
+			Created int64  `json:"created"`
+			OwnedBy string `json:"owned_by"`
+		}
+		models := []model{}
+		for _, name := range names {
+			models = append(models, model{ID: name, Object: "model", OwnedBy: "lucidsearch"})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": models})
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
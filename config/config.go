@@ -0,0 +1,213 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+type Config struct {
+	Scraper  Scraper  `json:"scraper"`
+	Cache    Cache    `json:"cache"`
+	Podcasts Podcasts `json:"podcasts"`
+	Trust    Trust    `json:"trust"`
+
+	Providers Providers `json:"providers"`
+
+	// Profiles add search profiles or replace the built-in ones by name.
+	Profiles map[string]Profile `json:"profiles"`
+
+	// EmbeddingCache is the bbolt file embeddings are cached in, by default
+	// "embeddings.db".
+	EmbeddingCache string `json:"embedding_cache"`
+
+	Quota Quota `json:"quota"`
+
+	Agent Agent `json:"agent"`
+
+	Sessions Sessions `json:"sessions"`
+
+	Ingest Ingest `json:"ingest"`
+
+	Auth Auth `json:"auth"`
+}
+
+// Auth protects the endpoints for other programs, /v1/chat/completions and
+// /mcp, which are closed until CHAT_API_KEY and MCP_TOKEN are set. Disabled
+// opens them without a token, e.g. behind a proxy that authenticates.
+type Auth struct {
+	Disabled bool `json:"disabled"`
+}
+
+// Ingest limits what is embedded per source. MaxDocuments caps the
+// documents embedded per result by kind: "pages", "slides", "transcript",
+// "sections" or "documents" for anything else; 0 removes the cap.
+type Ingest struct {
+	MaxDocuments map[string]int `json:"max_documents"`
+}
+
+// Sessions configures where conversations are kept: Store is "memory" (the
+// default) or "file", which keeps one file per session in Dir, by default
+// "sessions". Sessions expire after TTL without a turn, by default an hour.
+type Sessions struct {
+	Store string   `json:"store"`
+	Dir   string   `json:"dir"`
+	TTL   Duration `json:"ttl"`
+}
+
+// Agent limits agentic searches (agent=true), which research a question in
+// several rounds. MaxHops defaults to 3; requests may ask for fewer.
+type Agent struct {
+	MaxHops int `json:"max_hops"`
+}
+
+// Quota sets daily budgets of billed API calls, keyed by provider ("google")
+// or Gemini model name. Counts are kept in State, by default "quota.json",
+// and reset at midnight in Timezone, by default Pacific time.
+type Quota struct {
+	Budgets  map[string]int `json:"budgets"`
+	State    string         `json:"state"`
+	Timezone string         `json:"timezone"`
+}
+
+// Scraper configures how pages are fetched. AllowPrivate lets links reach
+// loopback, private and link-local addresses, which are refused by default.
+type Scraper struct {
+	UserAgent    string   `json:"user_agent"`
+	MaxPerHost   int      `json:"max_per_host"`
+	MinDelay     Duration `json:"min_delay"`
+	AllowPrivate bool     `json:"allow_private"`
+}
+
+// Cache is the on-disk cache of scraped pages. Dir defaults to "cache".
+type Cache struct {
+	Dir      string `json:"dir"`
+	MaxBytes int64  `json:"max_bytes"`
+}
+
+// Trust lists the domains results may (allow) or may not (deny) come from and
+// how much each is trusted. Keys are domains or TLDs such as ".gov".
+type Trust struct {
+	Allow   []string           `json:"allow"`
+	Deny    []string           `json:"deny"`
+	Weights map[string]float64 `json:"weights"`
+}
+
+// Providers holds the settings of search providers that have any.
+type Providers struct {
+	Google        Google        `json:"google"`
+	ArXiv         ArXiv         `json:"arxiv"`
+	MediaWiki     MediaWiki     `json:"wikipedia"`
+	CourtListener CourtListener `json:"courtlistener"`
+}
+
+type CourtListener struct {
+	// APIURL points the courtlistener provider at another
+	// CourtListener-compatible API root, e.g. a self-hosted instance.
+	APIURL string `json:"api_url"`
+}
+
+// Google narrows the google and ted providers' searches.
+type Google struct {
+	DateRestrict string `json:"date_restrict"` // e.g. d7, m6, y1
+	Site         string `json:"site"`          // google only
+	Language     string `json:"language"`      // e.g. lang_en
+	SafeSearch   bool   `json:"safe_search"`
+}
+
+type MediaWiki struct {
+	// APIURL points the wikipedia provider at another wiki's api.php.
+	APIURL string `json:"api_url"`
+}
+
+type ArXiv struct {
+	// FullText downloads and indexes each paper's PDF besides its abstract.
+	FullText bool `json:"full_text"`
+}
+
+// Profile is a named search setup, selected per request with ?profile=.
+type Profile struct {
+	Providers     []ProfileProvider `json:"providers"`
+	Trust         Trust             `json:"trust"`
+	Instructions  string            `json:"instructions"`
+	CitationStyle string            `json:"citation_style"`
+
+	// Rewrite has the query rewritten for search and answered
+	// hypothetically for retrieval (HyDE) before searching.
+	Rewrite bool `json:"rewrite"`
+
+	// The answer is withheld when fewer than MinSources sources have chunks
+	// scoring at least MinScore.
+	MinScore   float64 `json:"min_score"`
+	MinSources int     `json:"min_sources"`
+}
+
+type ProfileProvider struct {
+	Name       string `json:"name"`
+	MaxResults int    `json:"max_results"`
+}
+
+// Podcasts lists the feeds to ingest. The episodes already ingested are
+// kept in State, by default "podcasts.json", so restarts don't ingest them
+// again.
+type Podcasts struct {
+	Refresh Duration      `json:"refresh"`
+	Feeds   []PodcastFeed `json:"feeds"`
+	State   string        `json:"state"`
+}
+
+type PodcastFeed struct {
+	URL         string `json:"url"`
+	Name        string `json:"name"`
+	MaxEpisodes int    `json:"max_episodes"`
+}
+
+// Duration reads either a Go duration string ("6h") or a number of seconds.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		d.Duration = time.Duration(value * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		d.Duration = parsed
+	default:
+		return fmt.Errorf("invalid duration: %s", data)
+	}
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Load reads a JSON config file. A missing file is not an error and gives the
+// zero Config, so the service still runs without one.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config %s: %v", path, err)
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,62 @@
+package dedupe
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Query parameters that only track where a visitor came from.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "yclid": true,
+	"mc_cid": true, "mc_eid": true, "igshid": true, "_ga": true, "_gl": true,
+	"ref_src": true, "ref_url": true, "spm": true, "si": true,
+}
+
+// Canonicalize cleans up a link so the same page found through different
+// providers or links compares equal: the scheme and host are lowercased,
+// default ports, fragments, tracking parameters and trailing slashes are
+// dropped, and the remaining parameters are sorted. Links that don't parse
+// are returned as they are.
+func Canonicalize(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Host == "" {
+		return link
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	u.Host = strings.TrimSuffix(host, ".")
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.User = nil
+
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+
+	query := u.Query()
+	for key := range query {
+		lower := strings.ToLower(key)
+		if trackingParams[lower] || strings.HasPrefix(lower, "utm_") {
+			query.Del(key)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Key identifies the page a link points to, treating http and https and a
+// leading www. as the same site.
+func Key(link string) string {
+	canonical := Canonicalize(link)
+	u, err := url.Parse(canonical)
+	if err != nil || u.Host == "" {
+		return canonical
+	}
+	key := strings.TrimPrefix(u.Host, "www.") + u.EscapedPath()
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+	return key
+}
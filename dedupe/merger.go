@@ -0,0 +1,84 @@
+package dedupe
+
+import (
+	"lucidsearch/embedstore"
+	"strings"
+	"sync"
+)
+
+const (
+	// SimHashes this close are considered the same content.
+	maxDistance = 3
+	// Shorter content is too little to fingerprint reliably; snippets and
+	// abstracts of different papers can look alike.
+	minWords = 100
+)
+
+// Merger combines the results of concurrent providers, keeping one result
+// per page. It is safe for concurrent use.
+type Merger struct {
+	mu      sync.Mutex
+	links   map[string]bool   // keys of the links added
+	pages   map[string]string // canonical page keys, to the link whose content was kept
+	content []fingerprint
+}
+
+type fingerprint struct {
+	hash uint64
+	link string
+}
+
+func NewMerger() *Merger {
+	return &Merger{links: map[string]bool{}, pages: map[string]string{}}
+}
+
+// Add reports whether no earlier result pointed to the same page. The
+// canonical link is only the key; the result keeps its own link, which is
+// what is fetched and cited, since sites don't all serve the canonical form.
+func (m *Merger) Add(result embedstore.Result) (embedstore.Result, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := Key(result.Link)
+	if m.links[key] {
+		return result, false
+	}
+	m.links[key] = true
+	return result, true
+}
+
+// AddContent checks the scraped documents of a result before they are
+// embedded. It returns the link of an earlier result with the same content,
+// either because both name the same page in <link rel=canonical> or because
+// their text is nearly identical, or "" when the content is new.
+func (m *Merger) AddContent(link string, documents []embedstore.Document) string {
+	canonical := link
+	var sb strings.Builder
+	for _, document := range documents {
+		if declared := document.Metadata["canonical"]; declared != "" {
+			canonical = declared
+		}
+		sb.WriteString(document.PageContent)
+		sb.WriteString("\n")
+	}
+	text := sb.String()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := Key(canonical)
+	if other, ok := m.pages[key]; ok && other != link {
+		return other
+	}
+	m.pages[key] = link
+
+	if len(strings.Fields(text)) < minWords {
+		return ""
+	}
+	hash := SimHash(text)
+	for _, seen := range m.content {
+		if Distance(hash, seen.hash) <= maxDistance {
+			return seen.link
+		}
+	}
+	m.content = append(m.content, fingerprint{hash: hash, link: link})
+	return ""
+}
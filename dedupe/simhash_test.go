@@ -0,0 +1,72 @@
+package dedupe
+
+import (
+	"strings"
+	"testing"
+)
+
+const article = `Cover crops such as rye and clover are planted between cash crops to protect
+the soil. Their roots hold the soil against erosion through the winter, and legumes fix
+nitrogen that the next crop can use. Farmers who plant them report better water
+infiltration and fewer weeds, although the seed and the extra field work cost money.
+Researchers are still measuring how much carbon cover crops add to the soil over decades.
+In the Midwest, the area planted with cover crops has roughly doubled in ten years, helped
+by state programs that pay part of the seed cost. Extension agents recommend starting on a
+single field, choosing a species that suits the rotation, and terminating the cover early
+enough in spring that it does not compete with the next crop for water. Grazing cattle on
+the cover is another way to recover some of the cost while keeping the benefits for the soil.`
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b uint64
+		want int
+	}{
+		{name: "equal", a: 0xdeadbeef, b: 0xdeadbeef, want: 0},
+		{name: "one bit", a: 0, b: 1 << 63, want: 1},
+		{name: "all bits", a: 0, b: ^uint64(0), want: 64},
+		{name: "some bits", a: 0b1010, b: 0b0110, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Distance(tt.a, tt.b); got != tt.want {
+				t.Errorf("Distance(%x, %x) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimHash(t *testing.T) {
+	tests := []struct {
+		name        string
+		other       string
+		maxDistance int
+		minDistance int
+	}{
+		{name: "same text", other: article},
+		{name: "case, punctuation and line breaks", other: strings.ToUpper(strings.NewReplacer(",", ";", "\n", " ").Replace(article))},
+		{name: "one word edited", other: strings.Replace(article, "roughly doubled", "about doubled", 1), maxDistance: 8},
+		{
+			name:        "different article",
+			other:       `The city council voted on Tuesday to extend the bus network to the northern suburbs. The new routes start in spring, and fares stay the same for the first year while ridership is measured. Opponents argued the money should go to repairing roads instead.`,
+			maxDistance: 64,
+			minDistance: 16,
+		},
+	}
+	hash := SimHash(article)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			distance := Distance(hash, SimHash(tt.other))
+			if distance > tt.maxDistance || distance < tt.minDistance {
+				t.Errorf("distance %d, want %d to %d", distance, tt.minDistance, tt.maxDistance)
+			}
+		})
+	}
+
+	if SimHash("") != 0 || SimHash("  ...  ") != 0 {
+		t.Error("text without words should hash to 0")
+	}
+	if SimHash("two words") == 0 {
+		t.Error("text shorter than a shingle should still be hashed")
+	}
+}
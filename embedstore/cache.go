@@ -0,0 +1,116 @@
+package embedstore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"expvar"
+	"fmt"
+	"math"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	embeddingCacheHits   = expvar.NewInt("embedding_cache_hits")
+	embeddingCacheMisses = expvar.NewInt("embedding_cache_misses")
+)
+
+// EmbeddingCache keeps embeddings in a bbolt file so the same text is only
+// embedded once per model and task type. There is one bucket per model,
+// keyed by task type and the SHA-256 of the text. Hits and misses are
+// published as the embedding_cache_hits and embedding_cache_misses expvars.
+type EmbeddingCache struct {
+	db *bolt.DB
+}
+
+var embeddingCache *EmbeddingCache
+
+// SetEmbeddingCache sets the cache GetGeminiEmbedding consults before every
+// embed call. With none set every call goes to the API.
+func SetEmbeddingCache(c *EmbeddingCache) {
+	embeddingCache = c
+}
+
+func OpenEmbeddingCache(path string) (*EmbeddingCache, error) {
+	// bbolt locks the file; fail instead of hanging if the server has it open.
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening embedding cache %s: %v", path, err)
+	}
+	return &EmbeddingCache{db: db}, nil
+}
+
+func (c *EmbeddingCache) Close() error {
+	return c.db.Close()
+}
+
+func embeddingKey(taskType, text string) []byte {
+	sum := sha256.Sum256([]byte(text))
+	return append([]byte(taskType+":"), sum[:]...)
+}
+
+func (c *EmbeddingCache) Get(model, taskType, text string) ([]float32, bool) {
+	var embedding []float32
+	c.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(model))
+		if bucket == nil {
+			return nil
+		}
+		if value := bucket.Get(embeddingKey(taskType, text)); value != nil {
+			embedding = decodeEmbedding(value)
+		}
+		return nil
+	})
+	if embedding == nil {
+		embeddingCacheMisses.Add(1)
+		return nil, false
+	}
+	embeddingCacheHits.Add(1)
+	return embedding, true
+}
+
+func (c *EmbeddingCache) Put(model, taskType, text string, embedding []float32) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(model))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(embeddingKey(taskType, text), encodeEmbedding(embedding))
+	})
+}
+
+// Purge drops every embedding cached for model, e.g. once it is retired, and
+// returns how many there were.
+func (c *EmbeddingCache) Purge(model string) (int, error) {
+	count := 0
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(model))
+		if bucket == nil {
+			return nil
+		}
+		count = bucket.Stats().KeyN
+		return tx.DeleteBucket([]byte(model))
+	})
+	if errors.Is(err, bolt.ErrBucketNotFound) {
+		return 0, nil
+	}
+	return count, err
+}
+
+func encodeEmbedding(embedding []float32) []byte {
+	data := make([]byte, 4*len(embedding))
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	return data
+}
+
+func decodeEmbedding(data []byte) []float32 {
+	embedding := make([]float32, len(data)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return embedding
+}
@@ -2,8 +2,11 @@ package embedstore
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"lucidsearch/quota"
+	"sort"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -15,7 +18,7 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-func StoreInQdrant(title, link string, embedding []float32, chunkStr string) {
+func StoreInQdrant(title, link string, embedding []float32, chunkStr string, metadata map[string]string) {
 	conn, err := grpc.Dial("localhost:6334", grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
@@ -29,11 +32,15 @@ func StoreInQdrant(title, link string, embedding []float32, chunkStr string) {
 
 	id := uuid.NewString()
 
-	payload := map[string]*pb.Value{
-		"title": {Kind: &pb.Value_StringValue{StringValue: title}},
-		"link":  {Kind: &pb.Value_StringValue{StringValue: link}},
-		"text":  {Kind: &pb.Value_StringValue{StringValue: chunkStr}},
+	payload := map[string]*pb.Value{}
+	for key, value := range metadata {
+		if value != "" {
+			payload[key] = &pb.Value{Kind: &pb.Value_StringValue{StringValue: value}}
+		}
 	}
+	payload["title"] = &pb.Value{Kind: &pb.Value_StringValue{StringValue: title}}
+	payload["link"] = &pb.Value{Kind: &pb.Value_StringValue{StringValue: link}}
+	payload["text"] = &pb.Value{Kind: &pb.Value_StringValue{StringValue: chunkStr}}
 
 	_, err = client.Upsert(ctx, &pb.UpsertPoints{
 		CollectionName: "embeddings",
@@ -61,6 +68,134 @@ func StoreInQdrant(title, link string, embedding []float32, chunkStr string) {
 	// fmt.Printf("STORED: ID: %s, Payload: %+v\n", id, payload)
 }
 
+func keywordCondition(key, value string) *pb.Condition {
+	return &pb.Condition{
+		ConditionOneOf: &pb.Condition_Field{
+			Field: &pb.FieldCondition{
+				Key:   key,
+				Match: &pb.Match{MatchValue: &pb.Match_Keyword{Keyword: value}},
+			},
+		},
+	}
+}
+
+// sourceCondition matches the chunks stored for a source ID (see
+// Result.ID), including chunks stored before source IDs were, which only
+// have their link.
+func sourceCondition(sourceID string) *pb.Condition {
+	legacy := &pb.Condition{
+		ConditionOneOf: &pb.Condition_Filter{
+			Filter: &pb.Filter{
+				Must: []*pb.Condition{
+					keywordCondition("link", sourceID),
+					{ConditionOneOf: &pb.Condition_IsEmpty{IsEmpty: &pb.IsEmptyCondition{Key: "source_id"}}},
+				},
+			},
+		},
+	}
+	return &pb.Condition{
+		ConditionOneOf: &pb.Condition_Filter{
+			Filter: &pb.Filter{Should: []*pb.Condition{keywordCondition("source_id", sourceID), legacy}},
+		},
+	}
+}
+
+// HasContent reports whether chunks of the source with the given content
+// hash are already stored, so unchanged pages don't need to be embedded
+// again.
+func HasContent(sourceID, contentHash string) (bool, error) {
+	conn, err := grpc.Dial("localhost:6334", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return false, fmt.Errorf("did not connect: %w", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewPointsClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	exact := false
+	response, err := client.Count(ctx, &pb.CountPoints{
+		CollectionName: "embeddings",
+		Filter: &pb.Filter{
+			Must: []*pb.Condition{
+				keywordCondition("source_id", sourceID),
+				keywordCondition("content_hash", contentHash),
+			},
+		},
+		Exact: &exact,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to count points: %w", err)
+	}
+	return response.GetResult().GetCount() > 0, nil
+}
+
+// SetContentHash marks the chunks stored for a source ID with the hash of
+// the content they were made from, once all of them are stored, so
+// HasContent only finds complete sources.
+func SetContentHash(sourceID, contentHash string) error {
+	conn, err := grpc.Dial("localhost:6334", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("did not connect: %w", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewPointsClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	wait := true
+	_, err = client.SetPayload(ctx, &pb.SetPayloadPoints{
+		CollectionName: "embeddings",
+		Wait:           &wait,
+		Payload:        map[string]*pb.Value{"content_hash": {Kind: &pb.Value_StringValue{StringValue: contentHash}}},
+		PointsSelector: &pb.PointsSelector{
+			PointsSelectorOneOf: &pb.PointsSelector_Filter{
+				Filter: &pb.Filter{
+					Must: []*pb.Condition{keywordCondition("source_id", sourceID)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set content hash: %w", err)
+	}
+	return nil
+}
+
+// DeleteSource removes every chunk stored for a source ID, e.g. before
+// storing a changed version of the page.
+func DeleteSource(sourceID string) error {
+	conn, err := grpc.Dial("localhost:6334", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("did not connect: %w", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewPointsClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = client.Delete(ctx, &pb.DeletePoints{
+		CollectionName: "embeddings",
+		Points: &pb.PointsSelector{
+			PointsSelectorOneOf: &pb.PointsSelector_Filter{
+				Filter: &pb.Filter{
+					Must: []*pb.Condition{sourceCondition(sourceID)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete points: %w", err)
+	}
+	return nil
+}
+
 func SetupQdrantCollection(d int) {
 	conn, err := grpc.Dial("localhost:6334", grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
@@ -75,9 +210,15 @@ func SetupQdrantCollection(d int) {
 
 	dimension := d
 
-	_, _ = client.Delete(ctx, &pb.DeleteCollection{
+	exists, err := client.CollectionExists(ctx, &pb.CollectionExistsRequest{
 		CollectionName: "embeddings",
 	})
+	if err != nil {
+		log.Fatalf("could not check collection: %v", err)
+	}
+	if exists.GetResult().GetExists() {
+		return
+	}
 
 	_, err = client.Create(ctx, &pb.CreateCollection{
 		CollectionName: "embeddings",
@@ -95,7 +236,29 @@ func SetupQdrantCollection(d int) {
 	}
 }
 
-func SearchQdrant(queryEmbedding []float32, limit int, scoreThreshold float32) ([]string, error) {
+type ScoredPoint struct {
+	ID    string
+	Score float32
+}
+
+func SearchQdrant(queryEmbedding []float32, limit int, scoreThreshold float32) ([]ScoredPoint, error) {
+	return searchQdrant(queryEmbedding, limit, scoreThreshold, nil)
+}
+
+// SearchQdrantIDs is SearchQdrant limited to the points with the given IDs.
+func SearchQdrantIDs(queryEmbedding []float32, ids []string, limit int, scoreThreshold float32) ([]ScoredPoint, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	hasID := &pb.HasIdCondition{}
+	for _, id := range ids {
+		hasID.HasId = append(hasID.HasId, &pb.PointId{PointIdOptions: &pb.PointId_Uuid{Uuid: id}})
+	}
+	filter := &pb.Filter{Must: []*pb.Condition{{ConditionOneOf: &pb.Condition_HasId{HasId: hasID}}}}
+	return searchQdrant(queryEmbedding, limit, scoreThreshold, filter)
+}
+
+func searchQdrant(queryEmbedding []float32, limit int, scoreThreshold float32, filter *pb.Filter) ([]ScoredPoint, error) {
 	conn, err := grpc.Dial("localhost:6334", grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		return nil, fmt.Errorf("did not connect: %w", err)
@@ -110,6 +273,7 @@ func SearchQdrant(queryEmbedding []float32, limit int, scoreThreshold float32) (
 	searchResult, err := client.Search(ctx, &pb.SearchPoints{
 		CollectionName: "embeddings",
 		Vector:         queryEmbedding,
+		Filter:         filter,
 		Limit:          uint64(limit),
 		WithPayload: &pb.WithPayloadSelector{
 			SelectorOptions: &pb.WithPayloadSelector_Enable{
@@ -122,22 +286,44 @@ func SearchQdrant(queryEmbedding []float32, limit int, scoreThreshold float32) (
 		return nil, fmt.Errorf("failed to search Qdrant: %w", err)
 	}
 
-	var chunkIDs []string
+	var points []ScoredPoint
 	for _, result := range searchResult.Result {
-		chunkIDs = append(chunkIDs, result.Id.GetUuid())
+		points = append(points, ScoredPoint{ID: result.Id.GetUuid(), Score: result.Score})
 		// fmt.Println("SEARCH RESS : ", result.GetPayload(), result)
 	}
 
-	return chunkIDs, nil
+	return points, nil
 }
 
 type ChunkData struct {
-	Title string
-	Link  string
-	Text  string
+	ID       string
+	Title    string
+	Link     string
+	Text     string
+	Metadata map[string]string
+	Score    float32
 }
 
-func GetChunks(chunkIDs []string) ([]ChunkData, error) {
+// MergePoints combines the results of several searches, keeping each
+// point's best score, best first.
+func MergePoints(lists ...[]ScoredPoint) []ScoredPoint {
+	best := map[string]float32{}
+	for _, points := range lists {
+		for _, point := range points {
+			if score, ok := best[point.ID]; !ok || point.Score > score {
+				best[point.ID] = point.Score
+			}
+		}
+	}
+	merged := make([]ScoredPoint, 0, len(best))
+	for id, score := range best {
+		merged = append(merged, ScoredPoint{ID: id, Score: score})
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Score > merged[j].Score })
+	return merged
+}
+
+func GetChunks(points []ScoredPoint) ([]ChunkData, error) {
 	conn, err := grpc.Dial("localhost:6334", grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		return nil, fmt.Errorf("did not connect: %w", err)
@@ -150,7 +336,8 @@ func GetChunks(chunkIDs []string) ([]ChunkData, error) {
 	defer cancel()
 
 	var chunks []ChunkData
-	for _, chunkID := range chunkIDs {
+	for _, scored := range points {
+		chunkID := scored.ID
 		pointID := &pb.PointId{
 			PointIdOptions: &pb.PointId_Uuid{
 				Uuid: chunkID,
@@ -180,9 +367,12 @@ func GetChunks(chunkIDs []string) ([]ChunkData, error) {
 				textContent := text.GetStringValue()
 				if !strings.Contains(textContent, "::") && !strings.Contains(textContent, "{") && !strings.Contains(textContent, "}") {
 					cdata := ChunkData{
-						Title: payload["title"].GetStringValue(),
-						Link:  payload["link"].GetStringValue(),
-						Text:  textContent,
+						ID:       chunkID,
+						Title:    payload["title"].GetStringValue(),
+						Link:     payload["link"].GetStringValue(),
+						Text:     textContent,
+						Metadata: payloadMetadata(payload),
+						Score:    scored.Score,
 					}
 					chunks = append(chunks, cdata)
 				} else {
@@ -197,6 +387,20 @@ func GetChunks(chunkIDs []string) ([]ChunkData, error) {
 	return chunks, nil
 }
 
+func payloadMetadata(payload map[string]*pb.Value) map[string]string {
+	metadata := map[string]string{}
+	for key, value := range payload {
+		switch key {
+		case "title", "link", "text":
+			continue
+		}
+		if v, ok := value.GetKind().(*pb.Value_StringValue); ok {
+			metadata[key] = v.StringValue
+		}
+	}
+	return metadata
+}
+
 type Document struct {
 	PageContent string
 	Metadata    map[string]string
@@ -226,41 +430,43 @@ func ChunkToDocuments(chunks []ChunkData, maxTokens int) []Document {
 	return documents
 }
 
+// SplitContentByBytes packs whole paragraphs (separated by blank lines) into
+// chunks of at most maxBytes, only breaking a paragraph when it doesn't fit.
 func SplitContentByBytes(content string, maxBytes int) []string {
 	var chunks []string
 	var currentChunk strings.Builder
 	currentSize := 0
 
-	words := strings.Fields(content)
-	for _, word := range words {
-		wordSize := utf8.RuneCountInString(word)
-		if currentSize+wordSize+1 > maxBytes {
-			if currentChunk.Len() > 0 {
-				chunks = append(chunks, currentChunk.String())
-				currentChunk.Reset()
-				currentSize = 0
-			}
+	for _, paragraph := range strings.Split(content, "\n\n") {
+		separator := "\n\n"
+		for _, word := range strings.Fields(paragraph) {
+			wordSize := utf8.RuneCountInString(word)
+			if currentSize+wordSize+len(separator) > maxBytes {
+				if currentChunk.Len() > 0 {
+					chunks = append(chunks, currentChunk.String())
+					currentChunk.Reset()
+					currentSize = 0
+				}
 
-			if wordSize > maxBytes {
-				for start := 0; start < len(word); {
-					end := start + maxBytes
-					if end > len(word) {
-						end = len(word)
+				if wordSize > maxBytes {
+					for start := 0; start < len(word); {
+						end := start + maxBytes
+						if end > len(word) {
+							end = len(word)
+						}
+						chunks = append(chunks, word[start:end])
+						start = end
 					}
-					chunks = append(chunks, word[start:end])
-					start = end
+					continue
 				}
-			} else {
-				currentChunk.WriteString(word)
-				currentSize = wordSize
 			}
-		} else {
 			if currentChunk.Len() > 0 {
-				currentChunk.WriteString(" ")
-				currentSize++
+				currentChunk.WriteString(separator)
+				currentSize += len(separator)
 			}
 			currentChunk.WriteString(word)
 			currentSize += wordSize
+			separator = " "
 		}
 	}
 	if currentChunk.Len() > 0 && currentChunk.Len() < 9990 {
@@ -290,12 +496,39 @@ func SanitizeUTF8(input string) string {
 var totalChunks = 0
 
 type Result struct {
-	Title string `json:"title"`
-	Link  string `json:"link"`
-	IsTED bool   `json:"isted"`
+	Title       string `json:"title"`
+	Link        string `json:"link"`
+	IsTED       bool   `json:"isted"`
+	Snippet     string `json:"snippet"`
+	DisplayLink string `json:"displayLink"`
+	Mime        string `json:"mime"`
+
+	// Metadata is what the provider knows about the result, e.g. the page's
+	// meta tags. It fills in the fields the scraped documents lack.
+	Metadata map[string]string `json:"-"`
+
+	// ID identifies what was scraped when results share a link, such as
+	// podcast episodes that all link to the show's page. Stored chunks are
+	// replaced by ID, which defaults to the link.
+	ID string `json:"-"`
+
+	// Documents are set by providers that return content along with the
+	// result (e.g. abstracts), so the link doesn't need to be scraped.
+	Documents []Document `json:"-"`
+}
+
+// SourceID is the ID chunks of the result are stored under.
+func (r Result) SourceID() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return r.Link
 }
 
-func GetGeminiEmbedding(ctx context.Context, client *genai.Client, content, model, title string, result Result, isQuery bool) []float32 {
+// GetGeminiEmbedding embeds doc as a retrieval document, titled with the
+// result's title, and stores its chunks in Qdrant. It returns an error if
+// any chunk couldn't be embedded.
+func GetGeminiEmbedding(ctx context.Context, client *genai.Client, doc Document, model, title string, result Result) error {
 	const maxBytes = 9000
 	const maxChunks = 5
 
@@ -311,9 +544,19 @@ func GetGeminiEmbedding(ctx context.Context, client *genai.Client, content, mode
 	// 	fmt.Errorf("err")
 	// }
 
-	chunks := SplitContentByBytes(content, maxBytes)
+	if result.Title == "" {
+		result.Title = doc.Metadata["title"]
+	}
+
+	metadata := map[string]string{"source_id": result.SourceID()}
+	for key, value := range doc.Metadata {
+		metadata[key] = value
+	}
+
+	chunks := SplitContentByBytes(doc.PageContent, maxBytes)
 	// fmt.Println("SPLIT DOCS  : ", chunks)
 	processedChunks := 0
+	var failed error
 	// var combinedEmbedding []float32
 	for _, chunk := range chunks {
 		if processedChunks >= maxChunks {
@@ -321,25 +564,88 @@ func GetGeminiEmbedding(ctx context.Context, client *genai.Client, content, mode
 		}
 		chunk = SanitizeUTF8(chunk)
 		fmt.Printf("Processing chunk %d of size %d bytes\n", processedChunks, len(chunk))
-		em := client.EmbeddingModel(model)
-		res, err := em.EmbedContent(ctx, genai.Text(chunk))
+		values, err := embed(ctx, client, model, genai.TaskTypeRetrievalDocument, result.Title, chunk)
+		if errors.Is(err, quota.ErrExhausted) {
+			log.Printf("Not embedding %s: %v", result.Link, err)
+			return err
+		}
 		if err != nil {
-			fmt.Printf("failed to generate embedding: %w", err)
+			fmt.Printf("failed to generate embedding: %v\n", err)
+			failed = fmt.Errorf("failed to generate embedding: %w", err)
 			continue
 		}
-		if res.Embedding != nil && res.Embedding.Values != nil && chunk != "" {
-			StoreInQdrant(result.Title, result.Link, res.Embedding.Values, chunk)
+		if values != nil && chunk != "" {
+			StoreInQdrant(result.Title, result.Link, values, chunk, metadata)
 			processedChunks++
 			totalChunks++
 		}
-		if isQuery {
-			var combinedEmbedding []float32
-			combinedEmbedding = append(combinedEmbedding, res.Embedding.Values...)
-			return combinedEmbedding
-		}
 
 	}
 	// fmt.Println("CHONSKSS: ", chunks)
-	return nil
 
+	return failed
+}
+
+// EmbedQuery embeds a search query as a retrieval query. Unlike documents,
+// queries are never stored.
+func EmbedQuery(ctx context.Context, client *genai.Client, query, model string) ([]float32, error) {
+	values, err := embed(ctx, client, model, genai.TaskTypeRetrievalQuery, "", SanitizeUTF8(query))
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return nil, fmt.Errorf("no embedding returned for query")
+	}
+	return values, nil
+}
+
+// EmbedPassage embeds text as a retrieval document without storing it, e.g.
+// a hypothetical answer to search with (HyDE).
+func EmbedPassage(ctx context.Context, client *genai.Client, text, model string) ([]float32, error) {
+	values, err := embed(ctx, client, model, genai.TaskTypeRetrievalDocument, "", SanitizeUTF8(text))
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		return nil, fmt.Errorf("no embedding returned for passage")
+	}
+	return values, nil
+}
+
+// embed returns the embedding of text, from the embedding cache when it has
+// one. The title is only used for retrieval documents.
+func embed(ctx context.Context, client *genai.Client, model string, taskType genai.TaskType, title, text string) ([]float32, error) {
+	if taskType != genai.TaskTypeRetrievalDocument {
+		title = ""
+	}
+	// The title changes the embedding, so it is part of the cache key.
+	cacheText := text
+	if title != "" {
+		cacheText = title + "\x00" + text
+	}
+	if embeddingCache != nil {
+		if values, ok := embeddingCache.Get(model, taskType.String(), cacheText); ok {
+			return values, nil
+		}
+	}
+
+	if err := quota.Spend(model, 1); err != nil {
+		return nil, err
+	}
+	em := client.EmbeddingModel(model)
+	em.TaskType = taskType
+	res, err := em.EmbedContentWithTitle(ctx, title, genai.Text(text))
+	if err != nil {
+		return nil, err
+	}
+	if res.Embedding == nil || res.Embedding.Values == nil {
+		return nil, nil
+	}
+
+	if embeddingCache != nil {
+		if err := embeddingCache.Put(model, taskType.String(), cacheText, res.Embedding.Values); err != nil {
+			log.Printf("Error caching embedding: %v", err)
+		}
+	}
+	return res.Embedding.Values, nil
 }
@@ -0,0 +1,119 @@
+package extract
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gogs/chardet"
+	"golang.org/x/net/html/charset"
+)
+
+// ErrBodyTooLarge is returned when a response is bigger than MaxBodyBytes.
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// Page is a fetched response body, decompressed and, for text, in UTF-8.
+type Page struct {
+	URL         string
+	ContentType string
+	Header      http.Header
+	Body        []byte
+}
+
+// decodeContent undoes the Content-Encoding we asked for in Accept-Encoding.
+func decodeContent(resp *http.Response) (io.ReadCloser, error) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	var reader io.Reader
+	switch encoding {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding gzip response: %v", err)
+		}
+		reader = gz
+	case "deflate":
+		reader = flate.NewReader(resp.Body)
+	case "br":
+		reader = brotli.NewReader(resp.Body)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %s", encoding)
+	}
+
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return struct {
+		io.Reader
+		io.Closer
+	}{reader, resp.Body}, nil
+}
+
+func (f *Fetcher) readPage(resp *http.Response) (*Page, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > f.MaxBodyBytes {
+		return nil, fmt.Errorf("%w: over %d bytes", ErrBodyTooLarge, f.MaxBodyBytes)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	if isText(contentType) {
+		body = toUTF8(body, contentType)
+	}
+
+	return &Page{
+		URL:         resp.Request.URL.String(),
+		ContentType: contentType,
+		Header:      resp.Header,
+		Body:        body,
+	}, nil
+}
+
+func isText(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		mediaType == "application/xml" ||
+		mediaType == "application/json"
+}
+
+// toUTF8 converts body using the charset from the Content-Type header, a
+// BOM or <meta charset> for HTML, or chardet's best guess, in that order.
+func toUTF8(body []byte, contentType string) []byte {
+	name := ""
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		name = params["charset"]
+	}
+	if name == "" {
+		if _, detected, certain := charset.DetermineEncoding(body, contentType); certain {
+			name = detected
+		}
+	}
+	if name == "" {
+		if result, err := chardet.NewTextDetector().DetectBest(body); err == nil && result.Confidence >= 50 {
+			name = result.Charset
+		}
+	}
+
+	encoding, canonical := charset.Lookup(name)
+	if encoding == nil || canonical == "utf-8" {
+		return body
+	}
+	decoded, err := encoding.NewDecoder().Bytes(body)
+	if err != nil {
+		return body
+	}
+	return decoded
+}
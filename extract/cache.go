@@ -0,0 +1,321 @@
+package extract
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"lucidsearch/embedstore"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheBytes = 512 << 20
+
+	// Without max-age or Expires, a page is considered fresh for a tenth of
+	// the time since it was last modified (RFC 9111, 4.2.2), up to a day.
+	maxHeuristicFreshness = 24 * time.Hour
+)
+
+// Cache keeps scraped pages on disk: the response validators, the body and
+// the documents extracted from it. A fresh entry is served without touching
+// the network, a stale one is revalidated with a conditional request, and
+// either way the page is not parsed again. The least recently used entries
+// are evicted once the cache grows past MaxBytes.
+type Cache struct {
+	Dir      string
+	MaxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	size    int64
+}
+
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Expires      time.Time `json:"expires"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Accessed     time.Time `json:"accessed"`
+}
+
+var cache *Cache
+
+// SetCache puts c in front of the fetcher for pages Scrape downloads. With no
+// cache set every page is downloaded and parsed each time.
+func SetCache(c *Cache) {
+	cache = c
+}
+
+// NewCache opens the cache in dir, picking up the entries already there.
+func NewCache(dir string, maxBytes int64) (*Cache, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheBytes
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{Dir: dir, MaxBytes: maxBytes, entries: map[string]*cacheEntry{}}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		var entry cacheEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.URL == "" {
+			continue
+		}
+		c.entries[cacheKey(entry.URL)] = &entry
+		c.size += entry.Size
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) path(key, ext string) string {
+	return filepath.Join(c.Dir, key+ext)
+}
+
+func (c *Cache) lookup(url string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[cacheKey(url)]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	return *entry, true
+}
+
+func (e cacheEntry) fresh() bool {
+	return time.Now().Before(e.Expires)
+}
+
+// conditionalHeader asks the server to answer 304 Not Modified if the page
+// hasn't changed since it was cached.
+func (e cacheEntry) conditionalHeader() http.Header {
+	header := http.Header{}
+	if e.ETag != "" {
+		header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		header.Set("If-Modified-Since", e.LastModified)
+	}
+	return header
+}
+
+// documents returns the documents cached for entry, extracting them again
+// from the cached body if only that is left.
+func (c *Cache) documents(entry cacheEntry) ([]embedstore.Document, error) {
+	key := cacheKey(entry.URL)
+
+	var documents []embedstore.Document
+	data, err := os.ReadFile(c.path(key, ".docs"))
+	if err == nil {
+		err = json.Unmarshal(data, &documents)
+	}
+	if err != nil {
+		body, readErr := os.ReadFile(c.path(key, ".body"))
+		if readErr != nil {
+			c.remove(key)
+			return nil, readErr
+		}
+		if documents, err = extractDocuments(body, entry.ContentType, entry.URL); err != nil {
+			return nil, err
+		}
+	}
+
+	c.touch(key, nil)
+	return documents, nil
+}
+
+// revalidated records a 304 response for entry: the cached copy is good for
+// as long as the new headers say.
+func (c *Cache) revalidated(entry cacheEntry, header http.Header) {
+	expires, _ := freshUntil(header, time.Now())
+	c.touch(cacheKey(entry.URL), func(e *cacheEntry) {
+		e.Expires = expires
+		if etag := header.Get("ETag"); etag != "" {
+			e.ETag = etag
+		}
+		if lastModified := header.Get("Last-Modified"); lastModified != "" {
+			e.LastModified = lastModified
+		}
+	})
+}
+
+func (c *Cache) store(url string, page *Page, documents []embedstore.Document) {
+	expires, storable := freshUntil(page.Header, time.Now())
+	if !storable {
+		return
+	}
+
+	docs, err := json.Marshal(documents)
+	if err != nil {
+		return
+	}
+	key := cacheKey(url)
+	if err := writeFileAtomic(c.path(key, ".body"), page.Body); err != nil {
+		log.Printf("Error caching %s: %v", url, err)
+		return
+	}
+	if err := writeFileAtomic(c.path(key, ".docs"), docs); err != nil {
+		log.Printf("Error caching %s: %v", url, err)
+		return
+	}
+
+	entry := &cacheEntry{
+		URL:          url,
+		ETag:         page.Header.Get("ETag"),
+		LastModified: page.Header.Get("Last-Modified"),
+		Expires:      expires,
+		ContentType:  page.ContentType,
+		Size:         int64(len(page.Body) + len(docs)),
+		Accessed:     time.Now(),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[key]; ok {
+		c.size -= old.Size
+	}
+	c.entries[key] = entry
+	c.size += entry.Size
+	c.writeEntry(key, entry)
+	c.evict()
+}
+
+func (c *Cache) touch(key string, update func(*cacheEntry)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	if update != nil {
+		update(entry)
+	}
+	entry.Accessed = time.Now()
+	c.writeEntry(key, entry)
+}
+
+func (c *Cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+}
+
+func (c *Cache) removeLocked(key string) {
+	if entry, ok := c.entries[key]; ok {
+		c.size -= entry.Size
+		delete(c.entries, key)
+	}
+	for _, ext := range []string{".json", ".body", ".docs"} {
+		os.Remove(c.path(key, ext))
+	}
+}
+
+// writeEntry is called with c.mu held.
+func (c *Cache) writeEntry(key string, entry *cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := writeFileAtomic(c.path(key, ".json"), data); err != nil {
+		log.Printf("Error caching %s: %v", entry.URL, err)
+	}
+}
+
+// evict drops least recently used entries until the cache fits in MaxBytes.
+// It is called with c.mu held.
+func (c *Cache) evict() {
+	if c.size <= c.MaxBytes {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].Accessed.Before(c.entries[keys[j]].Accessed)
+	})
+	for _, key := range keys {
+		if c.size <= c.MaxBytes {
+			break
+		}
+		c.removeLocked(key)
+	}
+}
+
+// freshUntil works out how long a response may be served from the cache
+// without revalidation, and whether it may be cached at all.
+func freshUntil(header http.Header, now time.Time) (time.Time, bool) {
+	directives := map[string]string{}
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.ToLower(strings.TrimSpace(directive)), "=")
+		directives[name] = strings.Trim(value, `"`)
+	}
+	if _, ok := directives["no-store"]; ok {
+		return time.Time{}, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return now, true
+	}
+	if seconds, err := strconv.Atoi(directives["max-age"]); err == nil {
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// An invalid Expires means already expired.
+			return now, true
+		}
+		return t, true
+	}
+
+	if t, err := http.ParseTime(header.Get("Last-Modified")); err == nil && t.Before(now) {
+		return now.Add(min(now.Sub(t)/10, maxHeuristicFreshness)), true
+	}
+	return now, true
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
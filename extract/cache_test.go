@@ -0,0 +1,147 @@
+package extract
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFreshUntil(t *testing.T) {
+	now := time.Date(2024, 9, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		header       map[string]string
+		want         time.Time
+		wantStorable bool
+	}{
+		{name: "no-store", header: map[string]string{"Cache-Control": "no-store, max-age=60"}},
+		{name: "no-cache", header: map[string]string{"Cache-Control": "no-cache"}, want: now, wantStorable: true},
+		{name: "max-age", header: map[string]string{"Cache-Control": "public, Max-Age=\"600\""}, want: now.Add(10 * time.Minute), wantStorable: true},
+		{
+			name:   "max-age over Expires",
+			header: map[string]string{"Cache-Control": "max-age=60", "Expires": "Wed, 04 Sep 2024 12:00:00 GMT"},
+			want:   now.Add(time.Minute), wantStorable: true,
+		},
+		{name: "Expires", header: map[string]string{"Expires": "Wed, 04 Sep 2024 12:00:00 GMT"}, want: now.Add(24 * time.Hour), wantStorable: true},
+		{name: "invalid Expires", header: map[string]string{"Expires": "0"}, want: now, wantStorable: true},
+		{
+			name:   "tenth of the age",
+			header: map[string]string{"Last-Modified": "Tue, 03 Sep 2024 02:00:00 GMT"},
+			want:   now.Add(time.Hour), wantStorable: true,
+		},
+		{
+			name:   "heuristic capped at a day",
+			header: map[string]string{"Last-Modified": "Mon, 01 Jan 2024 00:00:00 GMT"},
+			want:   now.Add(maxHeuristicFreshness), wantStorable: true,
+		},
+		{
+			name:   "Last-Modified in the future",
+			header: map[string]string{"Last-Modified": "Wed, 04 Sep 2024 12:00:00 GMT"},
+			want:   now, wantStorable: true,
+		},
+		{name: "no caching headers", want: now, wantStorable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for key, value := range tt.header {
+				header.Set(key, value)
+			}
+			got, storable := freshUntil(header, now)
+			if storable != tt.wantStorable {
+				t.Fatalf("got storable %v, want %v", storable, tt.wantStorable)
+			}
+			if storable && !got.Equal(tt.want) {
+				t.Errorf("got fresh until %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	// Each page takes 100 bytes of body and 4 of documents ("null"), so
+	// two fit in 250 bytes.
+	const maxBytes = 250
+	page := func(url string) *Page {
+		return &Page{URL: url, ContentType: "text/html", Header: http.Header{"Cache-Control": {"max-age=3600"}}, Body: bytes.Repeat([]byte("a"), 100)}
+	}
+
+	tests := []struct {
+		name string
+		// Steps are "store <url>", "read <url>" or "reopen".
+		steps []string
+		want  []string // URLs cached at the end
+		gone  []string
+	}{
+		{
+			name:  "least recently stored evicted",
+			steps: []string{"store a", "store b", "store c"},
+			want:  []string{"b", "c"}, gone: []string{"a"},
+		},
+		{
+			name:  "reading keeps a page",
+			steps: []string{"store a", "store b", "read a", "store c"},
+			want:  []string{"a", "c"}, gone: []string{"b"},
+		},
+		{
+			name:  "storing again replaces the size",
+			steps: []string{"store a", "store b", "store b", "store b"},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "reopened cache keeps access order",
+			steps: []string{"store a", "store b", "read a", "reopen", "store c"},
+			want:  []string{"a", "c"}, gone: []string{"b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c, err := NewCache(dir, maxBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, step := range tt.steps {
+				// Access times must differ for the order to be defined.
+				time.Sleep(time.Millisecond)
+				action, url, _ := strings.Cut(step, " ")
+				switch action {
+				case "reopen":
+					if c, err = NewCache(dir, maxBytes); err != nil {
+						t.Fatal(err)
+					}
+				case "store":
+					c.store(url, page(url), nil)
+				case "read":
+					entry, ok := c.lookup(url)
+					if !ok {
+						t.Fatalf("%s: not cached", step)
+					}
+					if _, err := c.documents(entry); err != nil {
+						t.Fatalf("%s: %v", step, err)
+					}
+				}
+			}
+
+			for _, url := range tt.want {
+				if _, ok := c.lookup(url); !ok {
+					t.Errorf("%s evicted", url)
+				}
+			}
+			for _, url := range tt.gone {
+				if _, ok := c.lookup(url); ok {
+					t.Errorf("%s still cached", url)
+				}
+				if _, err := os.Stat(c.path(cacheKey(url), ".body")); !os.IsNotExist(err) {
+					t.Errorf("%s: body left on disk", url)
+				}
+			}
+			if c.size > maxBytes {
+				t.Errorf("cache holds %d bytes, over %d", c.size, maxBytes)
+			}
+		})
+	}
+}
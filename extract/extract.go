@@ -1,6 +1,11 @@
 package extract
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"lucidsearch/embedstore"
@@ -9,10 +14,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/tmc/langchaingo/schema"
-
 	"github.com/PuerkitoBio/goquery"
-	readability "github.com/go-shiori/go-readability"
 	"github.com/texttheater/golang-levenshtein/levenshtein"
 )
 
@@ -21,44 +23,93 @@ const (
 	geminiAPIURL    = "https://api.gemini.com/v1/embedding"
 )
 
-func Scrape(result embedstore.Result, tedTalks []TEDTalk) (string, error) {
+// Scrape fetches and extracts the documents behind a search result. Every
+// document is tagged with a content_hash of all of them, which stays the same
+// as long as the extracted text does, and with the result's metadata where
+// the page itself doesn't say. A page robots.txt disallows is represented
+// by the search engine's snippet, if the result has one, returned along with
+// ErrBlockedByRobots.
+func Scrape(result embedstore.Result, tedTalks []TEDTalk) ([]embedstore.Document, error) {
+	documents, err := scrape(result, tedTalks)
+	if errors.Is(err, ErrBlockedByRobots) && result.Snippet != "" {
+		documents = []embedstore.Document{{PageContent: result.Snippet, Metadata: map[string]string{"format": "snippet"}}}
+		tagDocuments(documents, result.Metadata)
+		return documents, err
+	}
+	if err != nil {
+		return documents, err
+	}
+	tagDocuments(documents, result.Metadata)
+	return documents, nil
+}
+
+// ScrapeFile extracts the documents of a local file, tagged like Scrape's.
+// Only the command line reads local files; Scrape never does, whatever a
+// result's link says.
+func ScrapeFile(path string) ([]embedstore.Document, error) {
+	documents, err := scrapeLocalFile(path)
+	if err != nil {
+		return documents, err
+	}
+	tagDocuments(documents, nil)
+	return documents, nil
+}
+
+func tagDocuments(documents []embedstore.Document, metadata map[string]string) {
+	hash := contentHash(documents)
+	for i := range documents {
+		if documents[i].Metadata == nil {
+			documents[i].Metadata = map[string]string{}
+		}
+		documents[i].Metadata["content_hash"] = hash
+		for key, value := range metadata {
+			if documents[i].Metadata[key] == "" {
+				documents[i].Metadata[key] = value
+			}
+		}
+	}
+}
+
+func contentHash(documents []embedstore.Document) string {
+	hash := sha256.New()
+	for _, document := range documents {
+		hash.Write([]byte(document.PageContent))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func scrape(result embedstore.Result, tedTalks []TEDTalk) ([]embedstore.Document, error) {
+	if len(result.Documents) > 0 {
+		return result.Documents, nil
+	}
 	if result.Link == "" {
-		return "", nil
+		return nil, nil
 	}
 
 	if result.IsTED {
 		fmt.Println("Ted talk scraping")
 		s := scrapeTedUrl(result, tedTalks)
 		fmt.Println("FETCHED FROM ted DB : ", s)
-		return s, nil
+		return []embedstore.Document{{PageContent: s}}, nil
+	} else if isMedia("", result.Link) {
+		return fetchMedia(result.Link)
 	} else {
-		return fetchURLContent(result.Link, 3, 1*time.Second, 5*time.Second)
+		return fetchURLContent(result.Link, 30*time.Second)
 	}
 }
 
 func scrapeTedUrl(result embedstore.Result, tedTalks []TEDTalk) string {
-	req, err := http.NewRequest("GET", result.Link, nil)
-	if err != nil {
-		log.Println("Error creating request:", err)
-		return ""
-	}
-
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	resp, err := http.DefaultClient.Do(req)
+	page, err := fetcher.Fetch(ctx, result.Link)
 	if err != nil {
 		log.Println("Error fetching the URL:", err)
 		return ""
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Println("Non-OK HTTP status:", resp)
-		return ""
-	}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.Body))
 	if err != nil {
 		log.Println("Error loading HTML document:", err)
 		return ""
@@ -74,6 +125,8 @@ func scrapeTedUrl(result embedstore.Result, tedTalks []TEDTalk) string {
 func ExtractContent(doc *goquery.Document) string {
 	selectors := []string{
 		"article",
+		"main",
+		"div.main-content",
 		".content",
 		".main",
 		".post",
@@ -85,81 +138,118 @@ func ExtractContent(doc *goquery.Document) string {
 		"#main",
 	}
 
+	doc.Find("script, style, noscript, nav, header, footer, aside, form").Remove()
+
 	for _, selector := range selectors {
 		selection := doc.Find(selector)
 		if selection.Length() > 0 {
-			return selection.Text()
+			return selectionText(selection)
 		}
 	}
 
 	// Fallback
 	log.Println("No content found with the provided selectors, extracting from body tag")
-	bodyContent := doc.Find("body").Text()
-	return bodyContent
+	return selectionText(doc.Find("body"))
 }
 
-func scrapeWebPage(doc *goquery.Document) string {
-	content := ExtractContent(doc)
-	text := cleanText(content)
-	return text
-}
+func fetchURLContent(url string, timeout time.Duration) ([]embedstore.Document, error) {
+	var cached cacheEntry
+	var header http.Header
+	if cache != nil {
+		var ok bool
+		if cached, ok = cache.lookup(url); ok {
+			if cached.fresh() {
+				if documents, err := cache.documents(cached); err == nil {
+					log.Printf("Using cached content for URL: %s", url)
+					return documents, nil
+				}
+			}
+			header = cached.conditionalHeader()
+		}
+	}
 
-func fetchURLContent(url string, maxRetries int, retryDelay time.Duration, timeout time.Duration) (string, error) {
 	log.Printf("Scraping content from URL: %s", url)
-	retries := 0
 
-	client := &http.Client{
-		Timeout: timeout,
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := fetcher.get(ctx, url, header)
+	if errors.Is(err, ErrBlockedByRobots) {
+		log.Printf("Skipping URL disallowed by robots.txt: %s", url)
+		return nil, err
 	}
+	if err != nil {
+		log.Printf("Failed to scrape content from URL: %s. Error: %v", url, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	for retries < maxRetries {
-		resp, err := client.Get(url)
-		if err != nil {
-			log.Printf("Error occurred while scraping URL: %s. Error: %v", url, err)
-			retries++
-			time.Sleep(retryDelay)
-			continue
+	if resp.StatusCode == http.StatusNotModified && header != nil {
+		cache.revalidated(cached, resp.Header)
+		if documents, err := cache.documents(cached); err == nil {
+			log.Printf("Cached content still valid for URL: %s", url)
+			return documents, nil
+		}
+		// The cached copy is gone; fetch the page unconditionally.
+		resp.Body.Close()
+		if resp, err = fetcher.Get(ctx, url); err != nil {
+			return nil, err
 		}
 		defer resp.Body.Close()
+	}
 
-		if resp.StatusCode == http.StatusOK {
-			doc, err := goquery.NewDocumentFromReader(resp.Body)
-			if err != nil {
-				log.Printf("Error parsing document from URL: %s. Error: %v", url, err)
-				return "", err
-			}
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Request failed with status code: %d", resp.StatusCode)
+		return nil, fmt.Errorf("request failed with status code: %d", resp.StatusCode)
+	}
 
-			selectors := []string{
-				"article",
-				"div.main-content",
-				"body",
-			}
+	if isMedia(resp.Header.Get("Content-Type"), url) {
+		resp.Body.Close()
+		return fetchMedia(url)
+	}
 
-			var mainText string
-			for _, selector := range selectors {
-				doc.Find(selector).Each(func(i int, s *goquery.Selection) {
-					text := cleanText(s.Text())
-					if text != "" {
-						mainText += text + "\n"
-					}
-				})
-				if mainText != "" {
-					break
-				}
-			}
+	page, err := fetcher.readPage(resp)
+	if err != nil {
+		log.Printf("Error reading body from URL: %s. Error: %v", url, err)
+		return nil, err
+	}
 
-			if strings.TrimSpace(mainText) == "" {
-				log.Printf("No content extracted from URL: %s", url)
-			}
-			return mainText, nil
-		} else {
-			log.Printf("Request failed with status code: %d", resp.StatusCode)
-			return "", fmt.Errorf("request failed with status code: %d", resp.StatusCode)
-		}
+	documents, err := extractDocuments(page.Body, page.ContentType, url)
+	if err != nil {
+		log.Printf("Error parsing document from URL: %s. Error: %v", url, err)
+		return nil, err
 	}
 
-	log.Printf("Failed to scrape content from URL: %s after %d retries.", url, maxRetries)
-	return "", fmt.Errorf("failed to scrape content from URL: %s after %d retries", url, maxRetries)
+	if len(documents) == 0 {
+		log.Printf("No content extracted from URL: %s", url)
+	}
+	if cache != nil {
+		cache.store(url, page, documents)
+	}
+	return documents, nil
+}
+
+// extractDocuments picks an extractor for body based on its content type and
+// leading bytes, defaulting to HTML.
+func extractDocuments(body []byte, contentType, url string) ([]embedstore.Document, error) {
+	if isPDF(contentType, body) {
+		return extractPDF(body)
+	}
+	if archive, format := openOffice(contentType, body); archive != nil {
+		return extractOffice(archive, format)
+	}
+	if format := transcriptFormat(contentType, url, body); format != "" {
+		return extractTranscript(body, format)
+	}
+
+	doc, err := extractHTML(body, url)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(doc.PageContent) == "" {
+		return nil, nil
+	}
+	return []embedstore.Document{doc}, nil
 }
 
 func cleanText(text string) string {
@@ -236,33 +326,3 @@ func scrapeTEDTalk(doc *goquery.Document, tedTalks []TEDTalk) string {
 		return "Could not extract TED Talk details."
 	}
 }
-
-// TODO
-func scrapeAndParse(url string) (schema.Document, error) {
-	// resp, err := http.Get(url)
-	// if err != nil {
-	// 	return schema.Document{}, err
-	// }
-	// defer resp.Body.Close()
-
-	// body, err := ioutil.ReadAll(resp.Body)
-	// if err != nil {
-	// 	return schema.Document{}, err
-	// }
-
-
-	article, err := readability.FromURL(url, 10*time.Second)
-	if err != nil {
-		log.Println("Error loading HTML document:", err)
-		return schema.Document{}, nil
-	}
-
-	// Create a Document object
-	return schema.Document{
-		PageContent: article.Content,
-		Metadata: map[string]any{
-			"name": article.Title,
-			"url":  url,
-		},
-	}, nil
-}
@@ -0,0 +1,60 @@
+package extract
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lucidsearch/embedstore"
+)
+
+func TestScrapeBlockedByRobots(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nDisallow: /private/\n"))
+			return
+		}
+		t.Errorf("fetched %s, which robots.txt disallows", r.URL.Path)
+	}))
+	defer server.Close()
+
+	testFetcher := NewFetcher(DefaultUserAgent, 1, 0)
+	testFetcher.AllowPrivate = true
+	defer SetFetcher(fetcher)
+	SetFetcher(testFetcher)
+
+	tests := []struct {
+		name    string
+		snippet string
+		want    string
+	}{
+		{name: "with a snippet", snippet: "Grazing permits are renewed every ten years.", want: "Grazing permits are renewed every ten years."},
+		{name: "without a snippet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			documents, err := Scrape(embedstore.Result{
+				Link:     server.URL + "/private/permits",
+				Snippet:  tt.snippet,
+				Metadata: map[string]string{"source": "google"},
+			}, nil)
+			if !errors.Is(err, ErrBlockedByRobots) {
+				t.Errorf("got error %v, want ErrBlockedByRobots", err)
+			}
+			if tt.want == "" {
+				if len(documents) != 0 {
+					t.Errorf("got documents %+v, want none", documents)
+				}
+				return
+			}
+			if len(documents) != 1 || documents[0].PageContent != tt.want {
+				t.Fatalf("got documents %+v, want the snippet", documents)
+			}
+			metadata := documents[0].Metadata
+			if metadata["format"] != "snippet" || metadata["source"] != "google" || metadata["content_hash"] == "" {
+				t.Errorf("got metadata %v", metadata)
+			}
+		})
+	}
+}
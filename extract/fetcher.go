@@ -0,0 +1,426 @@
+package extract
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	nurl "net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/temoto/robotstxt"
+)
+
+const (
+	DefaultUserAgent = "LucidSearch/0.1 (+https://github.com/rajathkotyal/LucidSearch)"
+
+	robotsTTL         = 24 * time.Hour
+	maxRobotsBytes    = 512 << 10
+	defaultMaxPerHost = 2
+
+	defaultMaxRetries   = 3
+	defaultBaseDelay    = 500 * time.Millisecond
+	defaultMaxDelay     = 30 * time.Second
+	defaultMaxBodyBytes = 20 << 20
+	defaultMaxRedirects = 5
+)
+
+// ErrBlockedByRobots is returned for URLs the site's robots.txt disallows.
+var ErrBlockedByRobots = errors.New("blocked by robots.txt")
+
+// ErrPrivateAddress is returned for URLs that resolve to loopback, private
+// or link-local addresses, unless the Fetcher allows them.
+var ErrPrivateAddress = errors.New("private network address")
+
+// Fetcher is the HTTP layer every scrape goes through. It identifies itself
+// with an honest User-Agent, honors robots.txt (cached per host) and limits
+// how hard any one host is hit: at most MaxPerHost requests in flight and
+// requests spaced by the robots.txt Crawl-delay, or MinDelay if larger.
+//
+// Transport errors, 429s and 5xx responses are retried up to MaxRetries times
+// with exponential backoff and jitter, waiting for Retry-After when the
+// server sends one.
+//
+// Unless AllowPrivate is set, it only connects to public addresses, checked
+// after DNS resolution and on every redirect, so links can't reach the
+// local network or cloud metadata endpoints.
+type Fetcher struct {
+	UserAgent    string
+	MaxPerHost   int
+	MinDelay     time.Duration
+	MaxRetries   int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	MaxBodyBytes int64
+	AllowPrivate bool
+	Client       *http.Client
+
+	mu     sync.Mutex
+	robots map[string]*robotsEntry
+	hosts  map[string]*hostState
+}
+
+type robotsEntry struct {
+	robots  *robotstxt.RobotsData
+	fetched time.Time
+	ready   chan struct{}
+}
+
+type hostState struct {
+	slots chan struct{}
+	mu    sync.Mutex
+	next  time.Time
+}
+
+func NewFetcher(userAgent string, maxPerHost int, minDelay time.Duration) *Fetcher {
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	if maxPerHost <= 0 {
+		maxPerHost = defaultMaxPerHost
+	}
+	f := &Fetcher{
+		UserAgent:    userAgent,
+		MaxPerHost:   maxPerHost,
+		MinDelay:     minDelay,
+		MaxRetries:   defaultMaxRetries,
+		BaseDelay:    defaultBaseDelay,
+		MaxDelay:     defaultMaxDelay,
+		MaxBodyBytes: defaultMaxBodyBytes,
+		robots:       map[string]*robotsEntry{},
+		hosts:        map[string]*hostState{},
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   f.checkAddress,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	f.Client = &http.Client{CheckRedirect: f.checkRedirect, Transport: transport}
+	return f
+}
+
+// checkAddress refuses connections to non-public addresses, as the dialer's
+// Control function: it sees the address DNS resolved to.
+func (f *Fetcher) checkAddress(network, address string, _ syscall.RawConn) error {
+	if f.AllowPrivate {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddress(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+// Shared address space for carrier-grade NAT, which net/netip doesn't
+// count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+var fetcher = NewFetcher(DefaultUserAgent, defaultMaxPerHost, 0)
+
+// SetFetcher replaces the Fetcher used by Scrape.
+func SetFetcher(f *Fetcher) {
+	fetcher = f
+}
+
+// Get fetches url once robots.txt allows it and the host has a free slot,
+// retrying failed attempts. The slot is held until the response body is
+// closed. Compressed responses are decoded, so the body is always the
+// identity encoding.
+func (f *Fetcher) Get(ctx context.Context, url string) (*http.Response, error) {
+	return f.get(ctx, url, nil)
+}
+
+// get is Get with extra request headers, e.g. for conditional requests.
+func (f *Fetcher) get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme: %s", u.Scheme)
+	}
+
+	robots := f.robotsFor(ctx, u)
+	if !robots.TestAgent(u.RequestURI(), f.UserAgent) {
+		return nil, fmt.Errorf("%w: %s", ErrBlockedByRobots, url)
+	}
+	crawlDelay := robots.FindGroup(f.UserAgent).CrawlDelay
+	host := f.host(u.Host)
+
+	for attempt := 0; ; attempt++ {
+		resp, err := f.do(ctx, host, url, header, max(crawlDelay, f.MinDelay))
+		if ctx.Err() != nil {
+			if resp != nil {
+				// Closing the body gives back the host slot.
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+
+		if errors.Is(err, ErrPrivateAddress) {
+			return nil, err
+		}
+		retryable := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !retryable || attempt >= f.MaxRetries {
+			return resp, err
+		}
+
+		delay := f.backoff(attempt)
+		if err == nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				if retryAfter > f.MaxDelay {
+					return resp, nil
+				}
+				delay = retryAfter
+				host.delayUntil(time.Now().Add(retryAfter))
+			}
+			resp.Body.Close()
+			log.Printf("Retrying %s in %v after status code: %d", url, delay, resp.StatusCode)
+		} else {
+			log.Printf("Retrying %s in %v after error: %v", url, delay, err)
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *Fetcher) do(ctx context.Context, host *hostState, url string, header http.Header, delay time.Duration) (*http.Response, error) {
+	select {
+	case host.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-host.slots }
+
+	if err := host.wait(ctx, delay); err != nil {
+		release()
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("User-Agent", f.UserAgent)
+	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	body, err := decodeContent(resp)
+	if err != nil {
+		resp.Body.Close()
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: body, release: release}
+	return resp, nil
+}
+
+// Fetch gets url and reads its body, failing on non-2xx responses and bodies
+// over MaxBodyBytes. Textual bodies are converted to UTF-8.
+func (f *Fetcher) Fetch(ctx context.Context, url string) (*Page, error) {
+	resp, err := f.Get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("request failed with status code: %d", resp.StatusCode)
+	}
+	return f.readPage(resp)
+}
+
+// Fetch gets url with the Fetcher Scrape uses.
+func Fetch(ctx context.Context, url string) (*Page, error) {
+	return fetcher.Fetch(ctx, url)
+}
+
+// backoff returns a random delay in [0, BaseDelay*2^attempt), capped at
+// MaxDelay ("full jitter").
+func (f *Fetcher) backoff(attempt int) time.Duration {
+	ceiling := f.BaseDelay << attempt
+	if ceiling <= 0 || ceiling > f.MaxDelay {
+		ceiling = f.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+func (f *Fetcher) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= defaultMaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", defaultMaxRedirects)
+	}
+	if !f.robotsFor(req.Context(), req.URL).TestAgent(req.URL.RequestURI(), f.UserAgent) {
+		return fmt.Errorf("%w: %s", ErrBlockedByRobots, req.URL)
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *Fetcher) host(name string) *hostState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	host, ok := f.hosts[name]
+	if !ok {
+		host = &hostState{slots: make(chan struct{}, f.MaxPerHost)}
+		f.hosts[name] = host
+	}
+	return host
+}
+
+// wait blocks until delay has passed since the previous request to the host.
+func (h *hostState) wait(ctx context.Context, delay time.Duration) error {
+	h.mu.Lock()
+	now := time.Now()
+	start := h.next
+	if start.Before(now) {
+		start = now
+	}
+	h.next = start.Add(delay)
+	h.mu.Unlock()
+
+	return sleep(ctx, time.Until(start))
+}
+
+// delayUntil holds back further requests to the host, e.g. for Retry-After.
+func (h *hostState) delayUntil(t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t.After(h.next) {
+		h.next = t
+	}
+}
+
+// robotsFor returns the robots.txt of u's host, fetching it at most once per
+// robotsTTL.
+func (f *Fetcher) robotsFor(ctx context.Context, u *nurl.URL) *robotstxt.RobotsData {
+	key := u.Scheme + "://" + u.Host
+
+	f.mu.Lock()
+	entry, ok := f.robots[key]
+	if ok && time.Since(entry.fetched) > robotsTTL {
+		select {
+		case <-entry.ready:
+			ok = false
+		default:
+		}
+	}
+	if !ok {
+		entry = &robotsEntry{fetched: time.Now(), ready: make(chan struct{})}
+		f.robots[key] = entry
+		f.mu.Unlock()
+
+		entry.robots = f.fetchRobots(key)
+		close(entry.ready)
+		return entry.robots
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		return &robotstxt.RobotsData{}
+	}
+	return entry.robots
+}
+
+func (f *Fetcher) fetchRobots(origin string) *robotstxt.RobotsData {
+	// Not tied to the caller's context: the result is shared with every
+	// other request to this host.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return &robotstxt.RobotsData{}
+	}
+	req.Header.Set("User-Agent", f.UserAgent)
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		// An unreachable host fails the page fetch anyway.
+		log.Printf("Error fetching robots.txt for %s: %v", origin, err)
+		return &robotstxt.RobotsData{}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsBytes))
+	if err != nil {
+		return &robotstxt.RobotsData{}
+	}
+	robots, err := robotstxt.FromStatusAndBytes(resp.StatusCode, body)
+	if err != nil {
+		log.Printf("Error parsing robots.txt for %s: %v", origin, err)
+		return &robotstxt.RobotsData{}
+	}
+	return robots
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,190 @@
+package extract
+
+import (
+	"bytes"
+	"log"
+	"lucidsearch/embedstore"
+	nurl "net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	readability "github.com/go-shiori/go-readability"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Readability output shorter than this is usually a cookie banner or an
+// index page, so the selector heuristics get a chance instead.
+const minArticleLength = 200
+
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Br: true, atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true,
+	atom.Figcaption: true, atom.Figure: true, atom.Footer: true, atom.Form: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Header: true, atom.Hr: true, atom.Li: true, atom.Main: true, atom.Nav: true,
+	atom.Ol: true, atom.P: true, atom.Pre: true, atom.Section: true, atom.Table: true,
+	atom.Tr: true, atom.Ul: true,
+}
+
+// extractHTML returns the main content of an HTML page with paragraph
+// boundaries kept as blank lines. Readability is tried first and the
+// ExtractContent selectors are used when it finds nothing useful.
+func extractHTML(body []byte, pageURL string) (embedstore.Document, error) {
+	u, _ := nurl.Parse(pageURL)
+	article, err := readability.FromReader(bytes.NewReader(body), u)
+	if err != nil {
+		log.Printf("Readability failed for URL: %s. Error: %v", pageURL, err)
+	} else if article.Node != nil {
+		text := paragraphText(article.Node)
+		if len(text) >= minArticleLength {
+			metadata := articleMetadata(article)
+			metadata["canonical"] = canonicalLink(body, u)
+			return embedstore.Document{
+				PageContent: text,
+				Metadata:    metadata,
+			}, nil
+		}
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return embedstore.Document{}, err
+	}
+	metadata := pageMetadata(doc)
+	metadata["canonical"] = canonicalLink(body, u)
+	return embedstore.Document{
+		PageContent: ExtractContent(doc),
+		Metadata:    metadata,
+	}, nil
+}
+
+func articleMetadata(article readability.Article) map[string]string {
+	metadata := map[string]string{
+		"title":     article.Title,
+		"byline":    article.Byline,
+		"site_name": article.SiteName,
+	}
+	if article.PublishedTime != nil {
+		metadata["published"] = article.PublishedTime.Format(time.RFC3339)
+	}
+	return metadata
+}
+
+func pageMetadata(doc *goquery.Document) map[string]string {
+	title := doc.Find("meta[property='og:title']").AttrOr("content", "")
+	if title == "" {
+		title = cleanText(doc.Find("title").First().Text())
+	}
+	return map[string]string{
+		"title":     title,
+		"byline":    doc.Find("meta[name='author']").AttrOr("content", ""),
+		"published": doc.Find("meta[property='article:published_time']").AttrOr("content", ""),
+		"site_name": doc.Find("meta[property='og:site_name']").AttrOr("content", ""),
+	}
+}
+
+// canonicalLink returns the absolute URL of the page's <link rel=canonical>,
+// or "" if it has none. Only the head is read.
+func canonicalLink(body []byte, base *nurl.URL) string {
+	tokenizer := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "head" {
+				return ""
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if string(name) == "body" {
+				return ""
+			}
+			if string(name) != "link" || !hasAttr {
+				continue
+			}
+			var rel, href string
+			for {
+				key, value, more := tokenizer.TagAttr()
+				switch string(key) {
+				case "rel":
+					rel = strings.ToLower(string(value))
+				case "href":
+					href = strings.TrimSpace(string(value))
+				}
+				if !more {
+					break
+				}
+			}
+			if !slices.Contains(strings.Fields(rel), "canonical") || href == "" {
+				continue
+			}
+			link, err := nurl.Parse(href)
+			if err != nil {
+				return ""
+			}
+			if base != nil {
+				link = base.ResolveReference(link)
+			}
+			return link.String()
+		}
+	}
+}
+
+func selectionText(selection *goquery.Selection) string {
+	var paragraphs []string
+	for _, node := range selection.Nodes {
+		if text := paragraphText(node); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// paragraphText flattens n to text, cleaning each block element on its own
+// and separating blocks with a blank line.
+func paragraphText(n *html.Node) string {
+	var paragraphs []string
+	var current strings.Builder
+
+	flush := func() {
+		if text := cleanText(current.String()); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+		current.Reset()
+	}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			current.WriteString(n.Data)
+			return
+		}
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Script, atom.Style, atom.Noscript, atom.Template:
+				return
+			}
+		}
+
+		block := n.Type == html.ElementNode && blockElements[n.DataAtom]
+		if block {
+			flush()
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			flush()
+		} else if n.DataAtom == atom.Td || n.DataAtom == atom.Th {
+			current.WriteString(" ")
+		}
+	}
+	walk(n)
+	flush()
+
+	return strings.Join(paragraphs, "\n\n")
+}
@@ -0,0 +1,117 @@
+package extract
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"lucidsearch/embedstore"
+	"mime"
+	"net/http"
+	nurl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Podcast episodes and talks are large, so media gets its own size cap and a
+// timeout long enough to download them.
+var maxMediaBytes int64 = 1 << 30
+
+const mediaTimeout = 10 * time.Minute
+
+var mediaExtensions = map[string]bool{
+	".mp3": true, ".m4a": true, ".aac": true, ".wav": true, ".ogg": true, ".oga": true,
+	".opus": true, ".flac": true, ".mp4": true, ".m4v": true, ".mov": true, ".webm": true,
+	".mkv": true, ".avi": true,
+}
+
+func isMedia(contentType, link string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/") {
+		return true
+	}
+	return mediaExtensions[mediaExtension(link)]
+}
+
+func mediaExtension(link string) string {
+	if u, err := nurl.Parse(link); err == nil {
+		link = u.Path
+	}
+	return strings.ToLower(path.Ext(link))
+}
+
+func scrapeLocalFile(path string) ([]embedstore.Document, error) {
+	if isMedia("", path) {
+		return transcribeFile(path, path)
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return extractDocuments(body, mime.TypeByExtension(filepath.Ext(path)), path)
+}
+
+// fetchMedia downloads an audio or video link to a temporary file and
+// transcribes it.
+func fetchMedia(link string) ([]embedstore.Document, error) {
+	if transcriber == nil {
+		log.Printf("Skipping media URL %s: no transcriber configured", link)
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaTimeout)
+	defer cancel()
+
+	resp, err := fetcher.Get(ctx, link)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading media: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request failed with status code: %d", resp.StatusCode)
+	}
+	if resp.ContentLength > maxMediaBytes {
+		return nil, fmt.Errorf("%w: %d bytes of media, over %d", ErrBodyTooLarge, resp.ContentLength, maxMediaBytes)
+	}
+
+	file, err := os.CreateTemp("", "lucidsearch-media-*"+mediaExtension(link))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// A cut-off download would be transcribed as if it were the whole
+	// recording.
+	n, err := io.Copy(file, io.LimitReader(resp.Body, maxMediaBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading media: %v", err)
+	}
+	if n > maxMediaBytes {
+		return nil, fmt.Errorf("%w: media over %d bytes", ErrBodyTooLarge, maxMediaBytes)
+	}
+	return transcribeFile(file.Name(), link)
+}
+
+func transcribeFile(path, link string) ([]embedstore.Document, error) {
+	if transcriber == nil {
+		log.Printf("Skipping media file %s: no transcriber configured", path)
+		return nil, nil
+	}
+
+	log.Printf("Transcribing media from: %s", link)
+	ctx, cancel := context.WithTimeout(context.Background(), mediaTimeout)
+	defer cancel()
+
+	cues, err := transcriber.Transcribe(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("error transcribing %s: %v", link, err)
+	}
+	return TranscriptDocuments(cues, map[string]string{
+		"format": strings.TrimPrefix(mediaExtension(link), "."),
+	}), nil
+}
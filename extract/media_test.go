@@ -0,0 +1,93 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeTranscriber records the files it is given and transcribes each as one
+// cue holding its size.
+type fakeTranscriber struct {
+	calls int
+	err   error
+}
+
+func (f *fakeTranscriber) Transcribe(ctx context.Context, path string) ([]Cue, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return []Cue{{Start: 0, End: time.Second, Text: strconv.FormatInt(info.Size(), 10) + " bytes"}}, nil
+}
+
+func TestFetchMediaSizeLimit(t *testing.T) {
+	defer func(limit int64) { maxMediaBytes = limit }(maxMediaBytes)
+	maxMediaBytes = 16
+
+	tests := []struct {
+		name    string
+		size    int
+		chunked bool // no Content-Length, so only the download can tell
+		wantErr bool
+	}{
+		{name: "under the limit", size: 10},
+		{name: "at the limit", size: 16},
+		{name: "Content-Length over the limit", size: 17, wantErr: true},
+		{name: "chunked body over the limit", size: 40, chunked: true, wantErr: true},
+		{name: "chunked body at the limit", size: 16, chunked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "audio/mpeg")
+				body := bytes.Repeat([]byte("a"), tt.size)
+				if tt.chunked {
+					w.Write(body[:1])
+					w.(http.Flusher).Flush()
+					w.Write(body[1:])
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(tt.size))
+				w.Write(body)
+			}))
+			defer server.Close()
+
+			testFetcher := NewFetcher(DefaultUserAgent, 1, 0)
+			testFetcher.AllowPrivate = true
+			defer SetFetcher(fetcher)
+			SetFetcher(testFetcher)
+			fake := &fakeTranscriber{}
+			defer SetTranscriber(transcriber)
+			SetTranscriber(fake)
+
+			documents, err := fetchMedia(server.URL + "/episode.mp3")
+			if tt.wantErr {
+				if !errors.Is(err, ErrBodyTooLarge) {
+					t.Errorf("got error %v, want ErrBodyTooLarge", err)
+				}
+				if fake.calls != 0 {
+					t.Error("an oversized download was transcribed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strconv.Itoa(tt.size) + " bytes"
+			if len(documents) != 1 || documents[0].PageContent != want {
+				t.Errorf("got documents %+v, want the whole file of %s transcribed", documents, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,568 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"lucidsearch/embedstore"
+	"mime"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var officeContentTypes = map[string]string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   "docx",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": "pptx",
+	"application/vnd.oasis.opendocument.text":                                   "odt",
+	"application/epub+zip":                                                      "epub",
+}
+
+var slideFile = regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+
+// Caps on the decompressed size of an entry and of all entries read from
+// one archive, so a small download can't expand without bound (a zip bomb).
+const (
+	maxEntryBytes   = 64 << 20
+	maxArchiveBytes = 256 << 20
+)
+
+// officeArchive is a document's zip archive, read within the size caps.
+type officeArchive struct {
+	*zip.Reader
+	remaining int64
+}
+
+// readFile reads an entry, refusing it if it decompresses to more than the
+// caps allow. The declared size is checked first, then the actual one.
+func (a *officeArchive) readFile(name string) ([]byte, error) {
+	file, err := a.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	limit := min(int64(maxEntryBytes), a.remaining)
+	if info, err := file.Stat(); err == nil && info.Size() > limit {
+		return nil, fmt.Errorf("%s is too large when decompressed (%d bytes)", name, info.Size())
+	}
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is too large when decompressed", name)
+	}
+	a.remaining -= int64(len(data))
+	return data, nil
+}
+
+// openOffice returns the zip archive and format of a DOCX, PPTX, ODT or EPUB
+// body, or a nil archive if body isn't one of them. Servers often send these
+// as application/octet-stream, so the archive layout is checked as well.
+func openOffice(contentType string, body []byte) (*officeArchive, string) {
+	if !bytes.HasPrefix(body, []byte("PK\x03\x04")) {
+		return nil, ""
+	}
+	reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, ""
+	}
+	archive := &officeArchive{Reader: reader, remaining: maxArchiveBytes}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if format, ok := officeContentTypes[mediaType]; ok {
+		return archive, format
+	}
+
+	if mimetype, err := archive.readFile("mimetype"); err == nil {
+		if format, ok := officeContentTypes[strings.TrimSpace(string(mimetype))]; ok {
+			return archive, format
+		}
+	}
+	if _, err := fs.Stat(archive, "word/document.xml"); err == nil {
+		return archive, "docx"
+	}
+	if _, err := fs.Stat(archive, "ppt/presentation.xml"); err == nil {
+		return archive, "pptx"
+	}
+	return nil, ""
+}
+
+func extractOffice(archive *officeArchive, format string) ([]embedstore.Document, error) {
+	switch format {
+	case "docx":
+		return extractDOCX(archive)
+	case "pptx":
+		return extractPPTX(archive)
+	case "odt":
+		return extractODT(archive)
+	case "epub":
+		return extractEPUB(archive)
+	}
+	return nil, fmt.Errorf("unsupported document format: %s", format)
+}
+
+// sectionBuilder groups paragraphs into one document per heading so chunks
+// can be cited by section.
+type sectionBuilder struct {
+	metadata   map[string]string
+	documents  []embedstore.Document
+	heading    string
+	paragraphs []string
+}
+
+func newSectionBuilder(metadata map[string]string) *sectionBuilder {
+	return &sectionBuilder{metadata: metadata}
+}
+
+func (b *sectionBuilder) addParagraph(text string) {
+	if text != "" {
+		b.paragraphs = append(b.paragraphs, text)
+	}
+}
+
+func (b *sectionBuilder) startSection(heading string) {
+	b.flush()
+	b.heading = heading
+}
+
+func (b *sectionBuilder) flush() {
+	if len(b.paragraphs) == 0 {
+		return
+	}
+	metadata := map[string]string{}
+	for key, value := range b.metadata {
+		metadata[key] = value
+	}
+	metadata["section"] = strconv.Itoa(len(b.documents) + 1)
+	metadata["heading"] = b.heading
+
+	b.documents = append(b.documents, embedstore.Document{
+		PageContent: strings.Join(b.paragraphs, "\n\n"),
+		Metadata:    metadata,
+	})
+	b.paragraphs = nil
+}
+
+func (b *sectionBuilder) build() []embedstore.Document {
+	b.flush()
+	return b.documents
+}
+
+// readProperties collects the text of the elements named in fields (by local
+// name) from a metadata file such as docProps/core.xml or meta.xml.
+func readProperties(archive *officeArchive, name string, fields map[string]string) map[string]string {
+	metadata := map[string]string{}
+	data, err := archive.readFile(name)
+	if err != nil {
+		return metadata
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if key, ok := fields[start.Name.Local]; ok && metadata[key] == "" {
+			var value string
+			if decoder.DecodeElement(&value, &start) == nil {
+				metadata[key] = strings.TrimSpace(value)
+			}
+		}
+	}
+	return metadata
+}
+
+func xmlAttr(start xml.StartElement, name string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func extractDOCX(archive *officeArchive) ([]embedstore.Document, error) {
+	data, err := archive.readFile("word/document.xml")
+	if err != nil {
+		return nil, fmt.Errorf("error reading DOCX: %v", err)
+	}
+
+	metadata := readProperties(archive, "docProps/core.xml", map[string]string{
+		"title":   "title",
+		"creator": "byline",
+		"created": "published",
+	})
+	metadata["format"] = "docx"
+	builder := newSectionBuilder(metadata)
+
+	var paragraph strings.Builder
+	inText, heading := false, false
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing DOCX: %v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				paragraph.Reset()
+				heading = false
+			case "pStyle":
+				style := strings.ToLower(xmlAttr(t, "val"))
+				heading = strings.HasPrefix(style, "heading") || style == "title"
+			case "t":
+				inText = true
+			case "tab", "br", "cr":
+				paragraph.WriteString(" ")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text := cleanText(paragraph.String())
+				if heading && text != "" {
+					builder.startSection(text)
+				}
+				builder.addParagraph(text)
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(t)
+			}
+		}
+	}
+	return builder.build(), nil
+}
+
+func extractPPTX(archive *officeArchive) ([]embedstore.Document, error) {
+	metadata := readProperties(archive, "docProps/core.xml", map[string]string{
+		"title":   "title",
+		"creator": "byline",
+		"created": "published",
+	})
+
+	var documents []embedstore.Document
+	for i, name := range slideOrder(archive) {
+		n := i + 1
+		data, err := archive.readFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading PPTX slide %d: %v", n, err)
+		}
+		text, err := slideText(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing PPTX slide %d: %v", n, err)
+		}
+		if text == "" {
+			continue
+		}
+
+		slideMetadata := map[string]string{
+			"format": "pptx",
+			"slide":  strconv.Itoa(n),
+		}
+		for key, value := range metadata {
+			slideMetadata[key] = value
+		}
+		documents = append(documents, embedstore.Document{
+			PageContent: text,
+			Metadata:    slideMetadata,
+		})
+	}
+	return documents, nil
+}
+
+// slideOrder returns the slide files in presentation order, the order of
+// ppt/presentation.xml's slide list, which the file names don't follow once
+// slides are moved. Without a readable slide list it falls back to the file
+// names.
+func slideOrder(archive *officeArchive) []string {
+	var presentation struct {
+		SlideIDs []struct {
+			RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sldIdLst>sldId"`
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	data, err := archive.readFile("ppt/presentation.xml")
+	if err == nil {
+		err = xml.Unmarshal(data, &presentation)
+	}
+	if err == nil {
+		data, err = archive.readFile("ppt/_rels/presentation.xml.rels")
+	}
+	if err == nil {
+		err = xml.Unmarshal(data, &rels)
+	}
+	if err == nil && len(presentation.SlideIDs) > 0 {
+		targets := map[string]string{}
+		for _, rel := range rels.Relationships {
+			target := rel.Target
+			if strings.HasPrefix(target, "/") {
+				target = strings.TrimPrefix(target, "/")
+			} else {
+				target = path.Join("ppt", target)
+			}
+			targets[rel.ID] = target
+		}
+		var names []string
+		for _, slide := range presentation.SlideIDs {
+			if name, ok := targets[slide.RelID]; ok {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			return names
+		}
+	}
+
+	slides := map[int]string{}
+	var numbers []int
+	for _, file := range archive.File {
+		if match := slideFile.FindStringSubmatch(file.Name); match != nil {
+			n, _ := strconv.Atoi(match[1])
+			slides[n] = file.Name
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	var names []string
+	for _, n := range numbers {
+		names = append(names, slides[n])
+	}
+	return names
+}
+
+func slideText(data []byte) (string, error) {
+	var paragraphs []string
+	var paragraph strings.Builder
+	inText := false
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				paragraph.Reset()
+			case "t":
+				inText = true
+			case "br":
+				paragraph.WriteString(" ")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				if text := cleanText(paragraph.String()); text != "" {
+					paragraphs = append(paragraphs, text)
+				}
+			}
+		case xml.CharData:
+			if inText {
+				paragraph.Write(t)
+			}
+		}
+	}
+	return strings.Join(paragraphs, "\n\n"), nil
+}
+
+func extractODT(archive *officeArchive) ([]embedstore.Document, error) {
+	data, err := archive.readFile("content.xml")
+	if err != nil {
+		return nil, fmt.Errorf("error reading ODT: %v", err)
+	}
+
+	metadata := readProperties(archive, "meta.xml", map[string]string{
+		"title":           "title",
+		"initial-creator": "byline",
+		"creator":         "byline",
+		"creation-date":   "published",
+	})
+	metadata["format"] = "odt"
+	builder := newSectionBuilder(metadata)
+
+	var paragraph strings.Builder
+	depth := 0
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing ODT: %v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p", "h":
+				if depth == 0 {
+					paragraph.Reset()
+				}
+				depth++
+			case "s", "tab", "line-break":
+				paragraph.WriteString(" ")
+			}
+		case xml.EndElement:
+			if t.Name.Local != "p" && t.Name.Local != "h" {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			text := cleanText(paragraph.String())
+			if t.Name.Local == "h" && text != "" {
+				builder.startSection(text)
+			}
+			builder.addParagraph(text)
+		case xml.CharData:
+			if depth > 0 {
+				paragraph.Write(t)
+			}
+		}
+	}
+	return builder.build(), nil
+}
+
+type epubPackage struct {
+	Metadata struct {
+		Title   []string `xml:"title"`
+		Creator []string `xml:"creator"`
+		Date    []string `xml:"date"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID   string `xml:"id,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// extractEPUB returns one document per chapter in spine order.
+func extractEPUB(archive *officeArchive) ([]embedstore.Document, error) {
+	container, err := archive.readFile("META-INF/container.xml")
+	if err != nil {
+		return nil, fmt.Errorf("error reading EPUB container: %v", err)
+	}
+	var rootfiles struct {
+		Rootfile []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := xml.Unmarshal(container, &rootfiles); err != nil || len(rootfiles.Rootfile) == 0 {
+		return nil, fmt.Errorf("error parsing EPUB container: %v", err)
+	}
+
+	opfPath := rootfiles.Rootfile[0].FullPath
+	data, err := archive.readFile(opfPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading EPUB package: %v", err)
+	}
+	var pkg epubPackage
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return nil, fmt.Errorf("error parsing EPUB package: %v", err)
+	}
+
+	metadata := map[string]string{"format": "epub"}
+	if len(pkg.Metadata.Title) > 0 {
+		metadata["title"] = strings.TrimSpace(pkg.Metadata.Title[0])
+	}
+	if len(pkg.Metadata.Creator) > 0 {
+		metadata["byline"] = strings.TrimSpace(strings.Join(pkg.Metadata.Creator, ", "))
+	}
+	if len(pkg.Metadata.Date) > 0 {
+		metadata["published"] = strings.TrimSpace(pkg.Metadata.Date[0])
+	}
+
+	hrefs := map[string]string{}
+	for _, item := range pkg.Manifest {
+		hrefs[item.ID] = item.Href
+	}
+
+	builder := newSectionBuilder(metadata)
+	for _, itemref := range pkg.Spine {
+		href, ok := hrefs[itemref.IDRef]
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		chapter, err := archive.readFile(path.Join(path.Dir(opfPath), href))
+		if err != nil {
+			continue
+		}
+		doc, err := html.Parse(bytes.NewReader(chapter))
+		if err != nil {
+			continue
+		}
+
+		builder.startSection(chapterHeading(doc))
+		if body := findElement(doc, atom.Body); body != nil {
+			builder.addParagraph(paragraphText(body))
+		}
+	}
+	return builder.build(), nil
+}
+
+func chapterHeading(doc *html.Node) string {
+	for _, a := range []atom.Atom{atom.H1, atom.H2, atom.H3, atom.Title} {
+		if n := findElement(doc, a); n != nil {
+			if heading := cleanText(paragraphText(n)); heading != "" {
+				return heading
+			}
+		}
+	}
+	return ""
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"hash/crc32"
+	"strconv"
+	"testing"
+)
+
+// zipEntry is a file of a test archive. A declared size other than zero is
+// written to its header in place of the real one, as a zip bomb might.
+type zipEntry struct {
+	name     string
+	content  []byte
+	declared uint64
+}
+
+func buildZip(t *testing.T, entries ...zipEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		if entry.declared == 0 {
+			f, err := w.Create(entry.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write(entry.content)
+			continue
+		}
+		var compressed bytes.Buffer
+		fw, _ := flate.NewWriter(&compressed, flate.BestSpeed)
+		fw.Write(entry.content)
+		fw.Close()
+		f, err := w.CreateRaw(&zip.FileHeader{
+			Name:               entry.name,
+			Method:             zip.Deflate,
+			CRC32:              crc32.ChecksumIEEE(entry.content),
+			CompressedSize64:   uint64(compressed.Len()),
+			UncompressedSize64: entry.declared,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(compressed.Bytes())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOfficeArchiveCaps(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []zipEntry
+		remaining int64 // left of the archive cap before reading
+		wantErr   []bool
+	}{
+		{
+			name:      "within the caps",
+			entries:   []zipEntry{{name: "a.xml", content: make([]byte, 100)}},
+			remaining: 1000,
+			wantErr:   []bool{false},
+		},
+		{
+			name:      "declared size over the archive cap",
+			entries:   []zipEntry{{name: "a.xml", content: make([]byte, 100)}},
+			remaining: 50,
+			wantErr:   []bool{true},
+		},
+		{
+			name:      "understated size over the archive cap",
+			entries:   []zipEntry{{name: "a.xml", content: make([]byte, 100), declared: 10}},
+			remaining: 50,
+			wantErr:   []bool{true},
+		},
+		{
+			name:      "second entry over what the first left",
+			entries:   []zipEntry{{name: "a.xml", content: make([]byte, 60)}, {name: "b.xml", content: make([]byte, 60)}},
+			remaining: 100,
+			wantErr:   []bool{false, true},
+		},
+		{
+			name:      "entry over the entry cap",
+			entries:   []zipEntry{{name: "a.xml", content: make([]byte, maxEntryBytes+1)}},
+			remaining: maxArchiveBytes,
+			wantErr:   []bool{true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := buildZip(t, tt.entries...)
+			reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive := &officeArchive{Reader: reader, remaining: tt.remaining}
+			for i, entry := range tt.entries {
+				data, err := archive.readFile(entry.name)
+				if tt.wantErr[i] {
+					if err == nil {
+						t.Errorf("%s: read %d bytes, want an error", entry.name, len(data))
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%s: %v", entry.name, err)
+				}
+				if len(data) != len(entry.content) {
+					t.Errorf("%s: got %d bytes, want %d", entry.name, len(data), len(entry.content))
+				}
+			}
+		})
+	}
+}
+
+func slideXML(text string) []byte {
+	return []byte(`<p:sld xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main"><p:cSld><p:spTree><p:sp><p:txBody><a:p><a:r><a:t>` + text + `</a:t></a:r></a:p></p:txBody></p:sp></p:spTree></p:cSld></p:sld>`)
+}
+
+func TestPPTXSlideOrder(t *testing.T) {
+	slides := []zipEntry{
+		{name: "ppt/slides/slide1.xml", content: slideXML("Written first")},
+		{name: "ppt/slides/slide2.xml", content: slideXML("Written second")},
+		{name: "ppt/slides/slide10.xml", content: slideXML("Written tenth")},
+	}
+	presentation := zipEntry{name: "ppt/presentation.xml", content: []byte(`<p:presentation xmlns:p="http://schemas.openxmlformats.org/presentationml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<p:sldIdLst><p:sldId id="256" r:id="rId4"/><p:sldId id="257" r:id="rId2"/><p:sldId id="258" r:id="rId3"/></p:sldIdLst></p:presentation>`)}
+	rels := zipEntry{name: "ppt/_rels/presentation.xml.rels", content: []byte(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Target="theme/theme1.xml"/>
+<Relationship Id="rId2" Target="slides/slide1.xml"/>
+<Relationship Id="rId3" Target="/ppt/slides/slide2.xml"/>
+<Relationship Id="rId4" Target="slides/slide10.xml"/>
+</Relationships>`)}
+
+	tests := []struct {
+		name    string
+		entries []zipEntry
+		want    []string
+	}{
+		{
+			name:    "slide list order",
+			entries: append([]zipEntry{presentation, rels}, slides...),
+			want:    []string{"Written tenth", "Written first", "Written second"},
+		},
+		{
+			name:    "file names without a slide list",
+			entries: slides,
+			want:    []string{"Written first", "Written second", "Written tenth"},
+		},
+		{
+			name:    "file names without the relationships",
+			entries: append([]zipEntry{presentation}, slides...),
+			want:    []string{"Written first", "Written second", "Written tenth"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, format := openOffice("application/vnd.openxmlformats-officedocument.presentationml.presentation", buildZip(t, tt.entries...))
+			if archive == nil || format != "pptx" {
+				t.Fatalf("not recognized as a PPTX: %q", format)
+			}
+			documents, err := extractOffice(archive, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(documents) != len(tt.want) {
+				t.Fatalf("got %d slides, want %d", len(documents), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if documents[i].PageContent != want || documents[i].Metadata["slide"] != strconv.Itoa(i+1) {
+					t.Errorf("slide %d: got %q (slide %s), want %q", i+1, documents[i].PageContent, documents[i].Metadata["slide"], want)
+				}
+			}
+		})
+	}
+}
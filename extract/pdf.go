@@ -0,0 +1,62 @@
+package extract
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"lucidsearch/embedstore"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+func isPDF(contentType string, body []byte) bool {
+	return strings.HasPrefix(contentType, "application/pdf") || bytes.HasPrefix(body, []byte("%PDF-"))
+}
+
+// extractPDF returns one document per page so every chunk embedded from it
+// can be cited with its page number.
+func extractPDF(body []byte) (documents []embedstore.Document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			documents = nil
+			err = fmt.Errorf("error reading PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening PDF: %v", err)
+	}
+
+	info := reader.Trailer().Key("Info")
+	title := info.Key("Title").Text()
+	author := info.Key("Author").Text()
+
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			log.Printf("Error extracting text from PDF page %d: %v", i, err)
+			continue
+		}
+		text = cleanText(text)
+		if text == "" {
+			continue
+		}
+		documents = append(documents, embedstore.Document{
+			PageContent: text,
+			Metadata: map[string]string{
+				"title":  title,
+				"byline": author,
+				"format": "pdf",
+				"page":   strconv.Itoa(i),
+			},
+		})
+	}
+	return documents, nil
+}
@@ -0,0 +1,110 @@
+package extract
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"lucidsearch/embedstore"
+)
+
+// testdata/regulations.pdf has text on pages 1 and 2 and a blank page 3.
+
+func TestExtractPDF(t *testing.T) {
+	body, err := os.ReadFile("testdata/regulations.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isPDF("application/octet-stream", body) {
+		t.Fatal("isPDF missed the %PDF- magic bytes")
+	}
+
+	documents, err := extractPDF(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ page, text string }{
+		{"1", "Grazing permits are renewed every ten years."},
+		{"2", "Appeals must be filed within thirty days."},
+	}
+	if len(documents) != len(want) {
+		t.Fatalf("got %d documents, want %d (the blank page skipped)", len(documents), len(want))
+	}
+	for i, w := range want {
+		document := documents[i]
+		if document.PageContent != w.text {
+			t.Errorf("page %s: got text %q, want %q", w.page, document.PageContent, w.text)
+		}
+		if document.Metadata["page"] != w.page {
+			t.Errorf("document %d: got page %q, want %q", i, document.Metadata["page"], w.page)
+		}
+		if document.Metadata["format"] != "pdf" {
+			t.Errorf("document %d: got format %q, want pdf", i, document.Metadata["format"])
+		}
+		if document.Metadata["title"] != "Grazing Regulations" || document.Metadata["byline"] != "Bureau of Land Management" {
+			t.Errorf("document %d: got title %q and byline %q from the PDF info", i, document.Metadata["title"], document.Metadata["byline"])
+		}
+	}
+}
+
+func TestExtractPDFCorrupt(t *testing.T) {
+	body, err := os.ReadFile("testdata/regulations.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extractPDF(body[:len(body)/2]); err == nil {
+		t.Error("expected an error for a truncated PDF")
+	}
+}
+
+func TestScrapePDF(t *testing.T) {
+	body, err := os.ReadFile("testdata/regulations.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Served without a PDF content type, as some sites do, so it is
+	// recognized by its magic bytes.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rules.pdf" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	// httptest listens on loopback, which the fetcher refuses by default.
+	testFetcher := NewFetcher(DefaultUserAgent, 1, 0)
+	testFetcher.AllowPrivate = true
+	defer SetFetcher(fetcher)
+	SetFetcher(testFetcher)
+
+	documents, err := Scrape(embedstore.Result{
+		Link:     server.URL + "/rules.pdf",
+		Metadata: map[string]string{"source": "google", "title": "Search title"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(documents) != 2 {
+		t.Fatalf("got %d documents, want one per page with text", len(documents))
+	}
+	hash := documents[0].Metadata["content_hash"]
+	for i, document := range documents {
+		if document.Metadata["page"] != strconv.Itoa(i+1) {
+			t.Errorf("document %d: got page %q", i, document.Metadata["page"])
+		}
+		if document.Metadata["source"] != "google" {
+			t.Errorf("document %d: result metadata not copied, got source %q", i, document.Metadata["source"])
+		}
+		if document.Metadata["title"] != "Grazing Regulations" {
+			t.Errorf("document %d: got title %q, want the PDF's own", i, document.Metadata["title"])
+		}
+		if document.Metadata["content_hash"] == "" || document.Metadata["content_hash"] != hash {
+			t.Errorf("document %d: got content_hash %q, want the same one for every page", i, document.Metadata["content_hash"])
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package extract
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Transcriber turns a local audio or video file into timed cues.
+type Transcriber interface {
+	Transcribe(ctx context.Context, path string) ([]Cue, error)
+}
+
+var transcriber Transcriber
+
+// SetTranscriber sets the Transcriber Scrape uses for audio and video. With
+// none set, media links are skipped.
+func SetTranscriber(t Transcriber) {
+	transcriber = t
+}
+
+// WhisperCPP runs a local whisper.cpp build on the CPU. Media is converted to
+// the 16kHz mono WAV whisper.cpp expects with ffmpeg first.
+type WhisperCPP struct {
+	Binary  string // whisper-cli (or "main" in older builds)
+	Model   string // path to a ggml model file
+	FFmpeg  string // defaults to "ffmpeg" on PATH
+	Threads int
+}
+
+func (w WhisperCPP) Transcribe(ctx context.Context, path string) ([]Cue, error) {
+	dir, err := os.MkdirTemp("", "lucidsearch-whisper")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	wav := filepath.Join(dir, "audio.wav")
+	ffmpeg := exec.CommandContext(ctx, cmp.Or(w.FFmpeg, "ffmpeg"), "-nostdin", "-y", "-i", path, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", wav)
+	if out, err := ffmpeg.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("error converting media with ffmpeg: %v: %s", err, tail(out))
+	}
+
+	base := filepath.Join(dir, "transcript")
+	args := []string{"-m", w.Model, "-f", wav, "-osrt", "-of", base, "-np"}
+	if w.Threads > 0 {
+		args = append(args, "-t", strconv.Itoa(w.Threads))
+	}
+	if out, err := exec.CommandContext(ctx, w.Binary, args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("error running whisper.cpp: %v: %s", err, tail(out))
+	}
+
+	srt, err := os.ReadFile(base + ".srt")
+	if err != nil {
+		return nil, fmt.Errorf("error reading whisper.cpp output: %v", err)
+	}
+	return ParseTranscript(srt, "srt")
+}
+
+// OpenAITranscriber calls an OpenAI-compatible /v1/audio/transcriptions
+// endpoint, such as OpenAI itself or a self-hosted faster-whisper server.
+type OpenAITranscriber struct {
+	BaseURL string
+	APIKey  string
+	Model   string // defaults to whisper-1
+	Client  *http.Client
+}
+
+func (o OpenAITranscriber) Transcribe(ctx context.Context, path string) ([]Cue, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		form.WriteField("model", cmp.Or(o.Model, "whisper-1"))
+		form.WriteField("response_format", "verbose_json")
+		part, err := form.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	endpoint := strings.TrimSuffix(strings.TrimRight(o.BaseURL, "/"), "/v1") + "/v1/audio/transcriptions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if o.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+
+	client := o.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error calling transcription endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("transcription request failed with status code: %d: %s", resp.StatusCode, tail(body))
+	}
+
+	cues, err := parseJSONTranscript(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(cues) == 0 {
+		// Servers that ignore verbose_json only return the plain text.
+		var plain struct {
+			Text string `json:"text"`
+		}
+		if json.Unmarshal(body, &plain) == nil && strings.TrimSpace(plain.Text) != "" {
+			cues = []Cue{{Text: cleanText(plain.Text)}}
+		}
+	}
+	return cues, nil
+}
+
+// CachedTranscriber keeps transcripts in Dir, keyed by the SHA-256 of the
+// media file, so a recording is only ever transcribed once.
+type CachedTranscriber struct {
+	Transcriber Transcriber
+	Dir         string
+}
+
+func (c CachedTranscriber) Transcribe(ctx context.Context, path string) ([]Cue, error) {
+	key, err := fileHash(path)
+	if err != nil {
+		return nil, err
+	}
+	cachePath := filepath.Join(c.Dir, key+".json")
+
+	if data, err := os.ReadFile(cachePath); err == nil {
+		return parseJSONTranscript(data)
+	}
+
+	cues, err := c.Transcriber.Transcribe(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeCueCache(cachePath, cues); err != nil {
+		// A failed cache write only costs a re-transcription later.
+		log.Printf("Error caching transcript %s: %v", cachePath, err)
+	}
+	return cues, nil
+}
+
+func writeCueCache(path string, cues []Cue) error {
+	type segment struct {
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Text  string  `json:"text"`
+	}
+	transcript := struct {
+		Segments []segment `json:"segments"`
+	}{Segments: []segment{}}
+	for _, cue := range cues {
+		transcript.Segments = append(transcript.Segments, segment{
+			Start: cue.Start.Seconds(),
+			End:   cue.End.Seconds(),
+			Text:  cue.Text,
+		})
+	}
+	data, err := json.Marshal(transcript)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+func fileHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// tail keeps error messages from external tools to their last few lines.
+func tail(out []byte) string {
+	out = bytes.TrimSpace(out)
+	if len(out) > 500 {
+		out = out[len(out)-500:]
+	}
+	return string(out)
+}
@@ -0,0 +1,181 @@
+package extract
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachedTranscriber(t *testing.T) {
+	media := t.TempDir()
+	fake := &fakeTranscriber{}
+	cached := CachedTranscriber{Transcriber: fake, Dir: filepath.Join(t.TempDir(), "transcripts")}
+
+	// Run in order against the same cache.
+	steps := []struct {
+		name      string
+		file      string
+		content   string
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "miss transcribes", file: "a.mp3", content: "first episode", wantCalls: 1},
+		{name: "same file is a hit", file: "a.mp3", content: "first episode", wantCalls: 1},
+		{name: "same content elsewhere is a hit", file: "copy.mp3", content: "first episode", wantCalls: 1},
+		{name: "changed content is a miss", file: "a.mp3", content: "first episode, re-cut", wantCalls: 2},
+		{name: "failure is not cached", file: "b.mp3", content: "second episode", err: errors.New("out of memory"), wantCalls: 3, wantErr: true},
+		{name: "retried after a failure", file: "b.mp3", content: "second episode", wantCalls: 4},
+		{name: "then cached", file: "b.mp3", content: "second episode", wantCalls: 4},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			path := filepath.Join(media, step.file)
+			if err := os.WriteFile(path, []byte(step.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			fake.err = step.err
+
+			cues, err := cached.Transcribe(context.Background(), path)
+			if fake.calls != step.wantCalls {
+				t.Errorf("transcriber called %d times in all, want %d", fake.calls, step.wantCalls)
+			}
+			if step.wantErr {
+				if err == nil {
+					t.Error("expected the transcriber's error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []Cue{{Start: 0, End: time.Second, Text: strconv.Itoa(len(step.content)) + " bytes"}}
+			if !reflect.DeepEqual(cues, want) {
+				t.Errorf("got cues %+v, want %+v", cues, want)
+			}
+
+			sum := sha256.Sum256([]byte(step.content))
+			if _, err := os.Stat(filepath.Join(cached.Dir, hex.EncodeToString(sum[:])+".json")); err != nil {
+				t.Errorf("transcript not cached by file hash: %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenAITranscriber(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseURL  string // relative to the server
+		model    string
+		status   int
+		response string
+		want     []Cue
+		wantErr  string
+	}{
+		{
+			name:     "verbose JSON segments",
+			baseURL:  "/v1",
+			status:   http.StatusOK,
+			response: `{"text":"Hello there. General Kenobi.","segments":[{"start":0.0,"end":1.5,"text":" Hello there."},{"start":1.5,"end":3.25,"text":" General Kenobi."}]}`,
+			want: []Cue{
+				{Start: 0, End: 1500 * time.Millisecond, Text: "Hello there."},
+				{Start: 1500 * time.Millisecond, End: 3250 * time.Millisecond, Text: "General Kenobi."},
+			},
+		},
+		{
+			name:     "plain text from a server ignoring verbose_json",
+			baseURL:  "/",
+			model:    "large-v3",
+			status:   http.StatusOK,
+			response: `{"text":" Just the text. "}`,
+			want:     []Cue{{Text: "Just the text."}},
+		},
+		{
+			name:     "error response",
+			baseURL:  "",
+			status:   http.StatusUnauthorized,
+			response: `{"error":{"message":"Incorrect API key provided"}}`,
+			wantErr:  "status code: 401: {\"error\":{\"message\":\"Incorrect API key provided\"}}",
+		},
+		{
+			name:     "server error",
+			baseURL:  "/v1/",
+			status:   http.StatusBadGateway,
+			response: "upstream timed out",
+			wantErr:  "status code: 502: upstream timed out",
+		},
+		{
+			name:     "malformed JSON",
+			baseURL:  "/v1",
+			status:   http.StatusOK,
+			response: `{"segments": [`,
+			wantErr:  "error parsing JSON transcript",
+		},
+	}
+
+	media := filepath.Join(t.TempDir(), "episode.mp3")
+	if err := os.WriteFile(media, []byte("ID3 audio bytes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/v1/audio/transcriptions" {
+					t.Errorf("got %s %s", r.Method, r.URL.Path)
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+					t.Errorf("got Authorization %q", got)
+				}
+				wantModel := tt.model
+				if wantModel == "" {
+					wantModel = "whisper-1"
+				}
+				if got := r.FormValue("model"); got != wantModel {
+					t.Errorf("got model %q, want %q", got, wantModel)
+				}
+				if got := r.FormValue("response_format"); got != "verbose_json" {
+					t.Errorf("got response_format %q", got)
+				}
+				file, header, err := r.FormFile("file")
+				if err != nil {
+					t.Errorf("no file uploaded: %v", err)
+				} else {
+					body, _ := io.ReadAll(file)
+					if header.Filename != "episode.mp3" || string(body) != "ID3 audio bytes" {
+						t.Errorf("got file %q with %q", header.Filename, body)
+					}
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			transcriber := OpenAITranscriber{BaseURL: server.URL + tt.baseURL, APIKey: "test-key", Model: tt.model}
+			cues, err := transcriber.Transcribe(context.Background(), media)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cues, tt.want) {
+				t.Errorf("got cues %+v, want %+v", cues, tt.want)
+			}
+		})
+	}
+}
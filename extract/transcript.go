@@ -0,0 +1,258 @@
+package extract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"lucidsearch/embedstore"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cue groups are kept well under the embedding chunk size so a retrieved
+// chunk always maps back to a single, reasonably tight time range.
+const maxCueGroupBytes = 2000
+
+var transcriptContentTypes = map[string]string{
+	"text/vtt":             "vtt",
+	"application/x-subrip": "srt",
+	"application/srt":      "srt",
+	"text/srt":             "srt",
+}
+
+// Cue is one timed line of a subtitle file or transcript.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// transcriptFormat reports whether body is a subtitle or JSON transcript file
+// and which one, or "" if it isn't.
+func transcriptFormat(contentType, url string, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if format, ok := transcriptContentTypes[mediaType]; ok {
+		return format
+	}
+
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("WEBVTT")):
+		return "vtt"
+	case strings.HasSuffix(strings.ToLower(url), ".srt") || looksLikeSRT(trimmed):
+		return "srt"
+	case bytes.HasPrefix(trimmed, []byte("{")) || bytes.HasPrefix(trimmed, []byte("[")):
+		if cues, err := parseJSONTranscript(trimmed); err == nil && len(cues) > 0 {
+			return "json"
+		}
+	}
+	return ""
+}
+
+// looksLikeSRT checks for a leading cue number followed by a timing line.
+func looksLikeSRT(body []byte) bool {
+	lines := strings.SplitN(strings.ReplaceAll(string(body[:min(len(body), 200)]), "\r\n", "\n"), "\n", 3)
+	if len(lines) < 2 {
+		return false
+	}
+	_, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	return err == nil && strings.Contains(lines[1], "-->")
+}
+
+// ParseTranscript reads SRT, WebVTT or JSON transcript cues from body.
+func ParseTranscript(body []byte, format string) ([]Cue, error) {
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+	switch format {
+	case "srt", "vtt":
+		return parseTimedText(string(body))
+	case "json":
+		return parseJSONTranscript(bytes.TrimSpace(body))
+	}
+	return nil, fmt.Errorf("unsupported transcript format: %s", format)
+}
+
+// parseTimedText handles both SRT and WebVTT: each blank-line separated block
+// with a "start --> end" line is a cue, everything else (headers, NOTE and
+// STYLE blocks) is skipped.
+func parseTimedText(text string) ([]Cue, error) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	var cues []Cue
+	for _, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		for i, line := range lines {
+			if !strings.Contains(line, "-->") {
+				continue
+			}
+			times := strings.SplitN(line, "-->", 2)
+			start, err := parseTimestamp(times[0])
+			if err != nil {
+				return nil, err
+			}
+			// WebVTT allows cue settings after the end time.
+			end, err := parseTimestamp(strings.Fields(times[1] + " ")[0])
+			if err != nil {
+				return nil, err
+			}
+
+			cueText := cleanText(html.UnescapeString(stripHTMLTags(strings.Join(lines[i+1:], " "))))
+			if cueText != "" {
+				cues = append(cues, Cue{Start: start, End: end, Text: cueText})
+			}
+			break
+		}
+	}
+	return cues, nil
+}
+
+// parseTimestamp accepts "hh:mm:ss,mmm" (SRT) and "[hh:]mm:ss.mmm" (WebVTT).
+func parseTimestamp(s string) (time.Duration, error) {
+	s = strings.Replace(strings.TrimSpace(s), ",", ".", 1)
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp: %q", s)
+	}
+
+	var total float64
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp: %q", s)
+		}
+		total = total*60 + value
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
+
+// jsonSegment covers the common JSON transcript shapes: Whisper/OpenAI
+// verbose_json segments, Podcasting 2.0 JSON and start/duration lists.
+type jsonSegment struct {
+	Start     *float64 `json:"start"`
+	End       *float64 `json:"end"`
+	Duration  *float64 `json:"duration"`
+	StartTime *float64 `json:"startTime"`
+	EndTime   *float64 `json:"endTime"`
+	Text      string   `json:"text"`
+	Body      string   `json:"body"`
+	Speaker   string   `json:"speaker"`
+}
+
+func parseJSONTranscript(body []byte) ([]Cue, error) {
+	var segments []jsonSegment
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := json.Unmarshal(body, &segments); err != nil {
+			return nil, fmt.Errorf("error parsing JSON transcript: %v", err)
+		}
+	} else {
+		var transcript struct {
+			Segments []jsonSegment `json:"segments"`
+		}
+		if err := json.Unmarshal(body, &transcript); err != nil {
+			return nil, fmt.Errorf("error parsing JSON transcript: %v", err)
+		}
+		segments = transcript.Segments
+	}
+
+	seconds := func(v float64) time.Duration {
+		return time.Duration(v * float64(time.Second))
+	}
+
+	var cues []Cue
+	lastSpeaker := ""
+	for _, segment := range segments {
+		text := segment.Text
+		if text == "" {
+			text = segment.Body
+		}
+		text = cleanText(text)
+		if text == "" {
+			continue
+		}
+		if segment.Speaker != "" && segment.Speaker != lastSpeaker {
+			text = segment.Speaker + ": " + text
+			lastSpeaker = segment.Speaker
+		}
+
+		var cue Cue
+		switch {
+		case segment.Start != nil:
+			cue.Start = seconds(*segment.Start)
+		case segment.StartTime != nil:
+			cue.Start = seconds(*segment.StartTime)
+		default:
+			continue
+		}
+		switch {
+		case segment.End != nil:
+			cue.End = seconds(*segment.End)
+		case segment.EndTime != nil:
+			cue.End = seconds(*segment.EndTime)
+		case segment.Duration != nil:
+			cue.End = cue.Start + seconds(*segment.Duration)
+		}
+		cue.Text = text
+		cues = append(cues, cue)
+	}
+	return cues, nil
+}
+
+// TranscriptDocuments packs consecutive cues into documents without ever
+// splitting a cue, recording the start and end time (in seconds) of each
+// group so citations can link to that point of the recording.
+func TranscriptDocuments(cues []Cue, metadata map[string]string) []embedstore.Document {
+	var documents []embedstore.Document
+	var group []string
+	var start, end time.Duration
+	size := 0
+
+	flush := func() {
+		if len(group) == 0 {
+			return
+		}
+		groupMetadata := map[string]string{}
+		for key, value := range metadata {
+			groupMetadata[key] = value
+		}
+		groupMetadata["start"] = formatSeconds(start)
+		groupMetadata["end"] = formatSeconds(end)
+
+		documents = append(documents, embedstore.Document{
+			PageContent: strings.Join(group, " "),
+			Metadata:    groupMetadata,
+		})
+		group = nil
+		size = 0
+	}
+
+	for _, cue := range cues {
+		if size > 0 && size+len(cue.Text)+1 > maxCueGroupBytes {
+			flush()
+		}
+		if len(group) == 0 {
+			start = cue.Start
+		}
+		group = append(group, cue.Text)
+		size += len(cue.Text) + 1
+		if cue.End > end || len(group) == 1 {
+			end = cue.End
+		}
+	}
+	flush()
+
+	return documents
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+func extractTranscript(body []byte, format string) ([]embedstore.Document, error) {
+	cues, err := ParseTranscript(body, format)
+	if err != nil {
+		return nil, err
+	}
+	return TranscriptDocuments(cues, map[string]string{"format": format}), nil
+}
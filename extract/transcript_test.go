@@ -0,0 +1,148 @@
+package extract
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTranscript(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name    string
+		format  string
+		body    string
+		want    []Cue
+		wantErr bool
+	}{
+		{
+			name:   "SRT",
+			format: "srt",
+			body: "\xef\xbb\xbf1\r\n00:00:01,000 --> 00:00:04,500\r\nCover crops hold\r\nthe soil.\r\n\r\n" +
+				"2\r\n00:00:04,500 --> 00:00:07,250\r\n<i>Nitrogen</i> &amp; carbon.\r\n",
+			want: []Cue{
+				{Start: time.Second, End: 4500 * ms, Text: "Cover crops hold the soil."},
+				{Start: 4500 * ms, End: 7250 * ms, Text: "Nitrogen & carbon."},
+			},
+		},
+		{
+			name:   "WebVTT with hours, settings and NOTE blocks",
+			format: "vtt",
+			body: "WEBVTT - Soil Matters\n\nNOTE recorded live\n\n" +
+				"intro\n00:59.500 --> 01:02.000 align:start position:10%\n<v Ann>Welcome back.</v>\n\n" +
+				"01:00:00.000 --> 01:00:03.000\nOne hour in.\n\n" +
+				"01:00:03.000 --> 01:00:04.000\n\n",
+			want: []Cue{
+				{Start: 59500 * ms, End: 62 * time.Second, Text: "Welcome back."},
+				{Start: time.Hour, End: time.Hour + 3*time.Second, Text: "One hour in."},
+			},
+		},
+		{
+			name:    "bad timestamp",
+			format:  "srt",
+			body:    "1\n00:00:aa,000 --> 00:00:04,000\nText\n",
+			wantErr: true,
+		},
+		{
+			name:   "Whisper verbose JSON",
+			format: "json",
+			body:   `{"text":"Hi. Bye.","segments":[{"start":0,"end":1.25,"text":" Hi."},{"start":1.25,"end":2,"text":" Bye."}]}`,
+			want: []Cue{
+				{Start: 0, End: 1250 * ms, Text: "Hi."},
+				{Start: 1250 * ms, End: 2 * time.Second, Text: "Bye."},
+			},
+		},
+		{
+			name:   "Podcasting 2.0 JSON with speakers",
+			format: "json",
+			body: `{"version":"1.0.0","segments":[{"speaker":"Ann","startTime":0.5,"endTime":2,"body":"Welcome."},` +
+				`{"speaker":"Ann","startTime":2,"endTime":3,"body":"Today, soil."},{"speaker":"Bo","startTime":3,"endTime":4,"body":"Thanks."}]}`,
+			want: []Cue{
+				{Start: 500 * ms, End: 2 * time.Second, Text: "Ann: Welcome."},
+				{Start: 2 * time.Second, End: 3 * time.Second, Text: "Today, soil."},
+				{Start: 3 * time.Second, End: 4 * time.Second, Text: "Bo: Thanks."},
+			},
+		},
+		{
+			name:   "start and duration list",
+			format: "json",
+			body:   `[{"start":10,"duration":2.5,"text":"Listed."},{"text":"No start, skipped."},{"start":13,"text":" "}]`,
+			want:   []Cue{{Start: 10 * time.Second, End: 12500 * ms, Text: "Listed."}},
+		},
+		{
+			name:    "malformed JSON",
+			format:  "json",
+			body:    `{"segments":`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown format",
+			format:  "ttml",
+			body:    "<tt/>",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cues, err := ParseTranscript([]byte(tt.body), tt.format)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got cues %+v, want an error", cues)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cues, tt.want) {
+				t.Errorf("got cues %+v, want %+v", cues, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscriptFormat(t *testing.T) {
+	tests := []struct {
+		name, contentType, url, body, want string
+	}{
+		{name: "VTT content type", contentType: "text/vtt; charset=utf-8", body: "anything", want: "vtt"},
+		{name: "SubRip content type", contentType: "application/x-subrip", want: "srt"},
+		{name: "WEBVTT header", contentType: "text/plain", body: "\xef\xbb\xbfWEBVTT\n\n00:01.000 --> 00:02.000\nHi", want: "vtt"},
+		{name: ".srt link", contentType: "text/plain", url: "https://soil.example/12.SRT", want: "srt"},
+		{name: "SRT cue layout", contentType: "text/plain", body: "1\r\n00:00:01,000 --> 00:00:02,000\r\nHi", want: "srt"},
+		{name: "JSON transcript", contentType: "application/json", body: `{"segments":[{"start":0,"end":1,"text":"Hi"}]}`, want: "json"},
+		{name: "other JSON", contentType: "application/json", body: `{"results":[]}`},
+		{name: "plain text", contentType: "text/plain", body: "Just a transcript."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transcriptFormat(tt.contentType, tt.url, []byte(tt.body)); got != tt.want {
+				t.Errorf("got format %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscriptDocuments(t *testing.T) {
+	long := strings.Repeat("word ", maxCueGroupBytes/5-1)
+	cues := []Cue{
+		{Start: 0, End: 2 * time.Second, Text: "First."},
+		{Start: 2 * time.Second, End: 3500 * time.Millisecond, Text: "Second."},
+		{Start: 3500 * time.Millisecond, End: 60 * time.Second, Text: long},
+	}
+	documents := TranscriptDocuments(cues, map[string]string{"format": "vtt"})
+	if len(documents) != 2 {
+		t.Fatalf("got %d documents, want the long cue in its own", len(documents))
+	}
+	want := []struct{ text, start, end string }{
+		{"First. Second.", "0", "3.5"},
+		{long, "3.5", "60"},
+	}
+	for i, w := range want {
+		document := documents[i]
+		if document.PageContent != w.text || document.Metadata["start"] != w.start || document.Metadata["end"] != w.end || document.Metadata["format"] != "vtt" {
+			t.Errorf("document %d: got %q with %v, want %s-%s", i, document.PageContent, document.Metadata, w.start, w.end)
+		}
+	}
+}
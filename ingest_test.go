@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"strconv"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+
+	"lucidsearch/embedstore"
+	"lucidsearch/extract"
+)
+
+// fakeKnowledgeBase stands in for Qdrant and Gemini in ingestDocuments,
+// recording what would be stored.
+type fakeKnowledgeBase struct {
+	stored []embedstore.Document
+	hashes map[string]string
+}
+
+func useFakeKnowledgeBase(t *testing.T) *fakeKnowledgeBase {
+	t.Helper()
+	kb := &fakeKnowledgeBase{hashes: map[string]string{}}
+	savedHas, savedDelete, savedStore, savedSet := hasContent, deleteSource, storeDocument, setContentHash
+	t.Cleanup(func() {
+		hasContent, deleteSource, storeDocument, setContentHash = savedHas, savedDelete, savedStore, savedSet
+	})
+
+	hasContent = func(sourceID, hash string) (bool, error) {
+		return kb.hashes[sourceID] == hash, nil
+	}
+	deleteSource = func(sourceID string) error {
+		kb.stored = nil
+		delete(kb.hashes, sourceID)
+		return nil
+	}
+	storeDocument = func(ctx context.Context, client *genai.Client, document embedstore.Document, model, title string, result embedstore.Result) error {
+		kb.stored = append(kb.stored, document)
+		return nil
+	}
+	setContentHash = func(sourceID, hash string) error {
+		kb.hashes[sourceID] = hash
+		return nil
+	}
+	return kb
+}
+
+// paperDocuments returns an arXiv-style result's documents: the abstract,
+// then the pages of testdata/survey-30-pages.pdf.
+func paperDocuments(t *testing.T) []embedstore.Document {
+	t.Helper()
+	pages, err := extract.ScrapeFile("testdata/survey-30-pages.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 30 {
+		t.Fatalf("got %d pages from the PDF, want 30", len(pages))
+	}
+	abstract := embedstore.Document{
+		PageContent: "We survey sparse attention methods.",
+		Metadata:    map[string]string{"source": "arxiv", "content_hash": pages[0].Metadata["content_hash"]},
+	}
+	return append([]embedstore.Document{abstract}, pages...)
+}
+
+// pmcDocuments returns a PubMed-style result's documents: the abstract, then
+// n sections of the PMC full text.
+func pmcDocuments(n int) []embedstore.Document {
+	documents := []embedstore.Document{{
+		PageContent: "Statins and muscle symptoms.",
+		Metadata:    map[string]string{"source": "pubmed", "content_hash": "pmc"},
+	}}
+	for i := 1; i <= n; i++ {
+		documents = append(documents, embedstore.Document{
+			PageContent: "Section " + strconv.Itoa(i),
+			Metadata:    map[string]string{"source": "pmc", "section": strconv.Itoa(i), "content_hash": "pmc"},
+		})
+	}
+	return documents
+}
+
+func plainDocuments(n int) []embedstore.Document {
+	var documents []embedstore.Document
+	for i := 1; i <= n; i++ {
+		documents = append(documents, embedstore.Document{PageContent: "Part " + strconv.Itoa(i), Metadata: map[string]string{}})
+	}
+	return documents
+}
+
+func TestIngestDocumentsCapsPerKind(t *testing.T) {
+	tests := []struct {
+		name      string
+		documents func(t *testing.T) []embedstore.Document
+		caps      map[string]int
+		want      map[string]int // stored documents by kind
+	}{
+		{
+			name:      "arXiv abstract and 30 PDF pages",
+			documents: paperDocuments,
+			want:      map[string]int{"documents": 1, "pages": 30},
+		},
+		{
+			name:      "arXiv pages over their own cap",
+			documents: paperDocuments,
+			caps:      map[string]int{"pages": 25},
+			want:      map[string]int{"documents": 1, "pages": 25},
+		},
+		{
+			name:      "PubMed abstract and 30 PMC sections",
+			documents: func(*testing.T) []embedstore.Document { return pmcDocuments(30) },
+			want:      map[string]int{"documents": 1, "sections": 30},
+		},
+		{
+			name:      "PMC sections over their own cap",
+			documents: func(*testing.T) []embedstore.Document { return pmcDocuments(30) },
+			caps:      map[string]int{"sections": 10},
+			want:      map[string]int{"documents": 1, "sections": 10},
+		},
+		{
+			name:      "other documents",
+			documents: func(*testing.T) []embedstore.Document { return plainDocuments(25) },
+			want:      map[string]int{"documents": 20},
+		},
+		{
+			name:      "cap removed",
+			documents: func(*testing.T) []embedstore.Document { return plainDocuments(25) },
+			caps:      map[string]int{"documents": 0},
+			want:      map[string]int{"documents": 25},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb := useFakeKnowledgeBase(t)
+			saved := maps.Clone(maxDocuments)
+			t.Cleanup(func() { maxDocuments = saved })
+			for kind, limit := range tt.caps {
+				maxDocuments[kind] = limit
+			}
+
+			ingestDocuments(context.Background(), nil, embedstore.Result{Link: "https://arxiv.org/abs/2401.01234v2"}, tt.documents(t))
+
+			got := map[string]int{}
+			for _, document := range kb.stored {
+				got[documentKind(document)]++
+				if document.Metadata["content_hash"] != "" {
+					t.Errorf("content_hash stored with the chunk: %v", document.Metadata)
+				}
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("stored %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngestDocumentsSkipsUnchangedContent(t *testing.T) {
+	kb := useFakeKnowledgeBase(t)
+	result := embedstore.Result{Link: "https://arxiv.org/abs/2401.01234v2"}
+	documents := paperDocuments(t)
+
+	ingestDocuments(context.Background(), nil, result, documents)
+	if len(kb.stored) != 31 || kb.hashes[result.SourceID()] == "" {
+		t.Fatalf("stored %d documents with hash %q, want all 31 and the hash recorded", len(kb.stored), kb.hashes[result.SourceID()])
+	}
+
+	kb.stored = append(kb.stored, embedstore.Document{PageContent: "marker"})
+	ingestDocuments(context.Background(), nil, result, documents)
+	if len(kb.stored) != 32 {
+		t.Errorf("unchanged content was embedded again: %d documents", len(kb.stored))
+	}
+}
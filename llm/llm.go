@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"lucidsearch/quota"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/iterator"
+)
+
+// Generator writes text for a prompt, e.g. an answer from retrieved context
+// or rewrites of a search query.
+type Generator interface {
+	Generate(ctx context.Context, prompt string, maxTokens int) (string, error)
+}
+
+// Streamer is a Generator that can hand out the text as it is written.
+type Streamer interface {
+	Generator
+	// Stream calls emit with each piece of the text and returns all of it.
+	Stream(ctx context.Context, prompt string, maxTokens int, emit func(string)) (string, error)
+}
+
+// Gemini generates text with a Gemini model, counting every call against
+// the model's daily quota.
+type Gemini struct {
+	Client *genai.Client
+	Model  string
+}
+
+// Generate returns the model's answer to prompt. maxTokens limits its
+// length; 0 leaves it to the model.
+func (g Gemini) Generate(ctx context.Context, prompt string, maxTokens int) (string, error) {
+	if err := quota.Spend(g.Model, 1); err != nil {
+		return "", err
+	}
+	model := g.Client.GenerativeModel(g.Model)
+	if maxTokens > 0 {
+		model.SetMaxOutputTokens(int32(maxTokens))
+	}
+	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	if err != nil {
+		return "", err
+	}
+
+	text := responseText(resp)
+	if text == "" {
+		return "", fmt.Errorf("%s returned no text", g.Model)
+	}
+	return text, nil
+}
+
+// Stream is Generate, streaming the answer. A single call is counted
+// against the quota.
+func (g Gemini) Stream(ctx context.Context, prompt string, maxTokens int, emit func(string)) (string, error) {
+	if err := quota.Spend(g.Model, 1); err != nil {
+		return "", err
+	}
+	model := g.Client.GenerativeModel(g.Model)
+	if maxTokens > 0 {
+		model.SetMaxOutputTokens(int32(maxTokens))
+	}
+	iter := model.GenerateContentStream(ctx, genai.Text(prompt))
+
+	var sb strings.Builder
+	for {
+		resp, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return sb.String(), err
+		}
+		if text := responseText(resp); text != "" {
+			sb.WriteString(text)
+			emit(text)
+		}
+	}
+	if sb.Len() == 0 {
+		return "", fmt.Errorf("%s returned no text", g.Model)
+	}
+	return sb.String(), nil
+}
+
+func responseText(resp *genai.GenerateContentResponse) string {
+	var sb strings.Builder
+	for _, cand := range resp.Candidates {
+		if cand.Content == nil {
+			continue
+		}
+		for _, part := range cand.Content.Parts {
+			if text, ok := part.(genai.Text); ok {
+				sb.WriteString(string(text))
+			}
+		}
+		// Only one candidate is requested.
+		break
+	}
+	return sb.String()
+}
@@ -1,28 +1,47 @@
 package main
 
 import (
+	"compress/bzip2"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"maps"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
 
+	"lucidsearch/caselaw"
+	"lucidsearch/config"
 	"lucidsearch/embedstore"
 	"lucidsearch/extract"
+	"lucidsearch/podcast"
+	"lucidsearch/profile"
+	"lucidsearch/provider"
+	"lucidsearch/quota"
+	"lucidsearch/rewrite"
+	"lucidsearch/session"
+	"lucidsearch/trust"
+	"lucidsearch/wiki"
 
 	"google.golang.org/api/option"
 )
 
 const (
-	googleSearchURL = "https://www.googleapis.com/customsearch/v1"
-	geminiAPIURL    = "https://api.gemini.com/v1/embedding"
+	geminiAPIURL = "https://api.gemini.com/v1/embedding"
 )
 
 type Result struct {
@@ -60,96 +79,33 @@ func loadEnvVars() {
 	}
 }
 
-func GoogleSearch(query string, maxResults int, resultsCh chan<- embedstore.Result, linkSet map[string]struct{}) {
-	u, err := url.Parse(googleSearchURL)
-	if err != nil {
-		log.Fatal(err)
-	}
-	q := u.Query()
-	q.Set("q", url.QueryEscape(query))
-	q.Set("key", apiKey)
-	q.Set("cx", cxID)
-	u.RawQuery = q.Encode()
-
-	// Log the full URL for debugging purposes
-	log.Println("Google Search URL:", u.String())
-
-	resp, err := http.Get(u.String())
-	if err != nil {
-		log.Println("Error making request to Google Search API:", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		log.Println("Non-OK HTTP status:", resp.StatusCode, resp)
-		return
-	}
-
-	var response struct {
-		Items []embedstore.Result `json:"items"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		log.Println("Error decoding response:", err)
-		return
-	}
-
-	for i, result := range response.Items {
-		if i >= maxResults {
-			break
+// setupTranscriber configures audio/video transcription from the environment:
+// WHISPER_CPP_BIN and WHISPER_CPP_MODEL for a local whisper.cpp, or
+// TRANSCRIBE_API_URL (plus TRANSCRIBE_API_KEY, TRANSCRIBE_MODEL) for an
+// OpenAI-compatible endpoint. Transcripts are cached in TRANSCRIPT_CACHE_DIR.
+func setupTranscriber() {
+	var transcriber extract.Transcriber
+	if bin := os.Getenv("WHISPER_CPP_BIN"); bin != "" {
+		transcriber = extract.WhisperCPP{
+			Binary: bin,
+			Model:  os.Getenv("WHISPER_CPP_MODEL"),
 		}
-		// if _, exists := linkSet[result.Link]; !exists {
-		// linkSet[result.Link] = struct{}{}
-		resultsCh <- result
-		// }
-	}
-}
-
-func TedSearch(query string, maxResults int, resultsCh chan<- embedstore.Result, linkSet map[string]struct{}) {
-	u, err := url.Parse(googleSearchURL)
-	if err != nil {
-		log.Fatal(err)
-	}
-	q := u.Query()
-	q.Set("q", fmt.Sprintf("TED Talk %s", url.QueryEscape(query)))
-	q.Set("key", apiKey)
-	q.Set("cx", cxID)
-	u.RawQuery = q.Encode()
-
-	// Log the full URL for debugging purposes
-	fmt.Println("TED Search URL:", u.String())
-
-	resp, err := http.Get(u.String())
-	if err != nil {
-		fmt.Println("Error making request to Google Search API - TED:", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Non-OK HTTP status:", resp.StatusCode)
-		return
-	}
-
-	var response struct {
-		Items []embedstore.Result `json:"items"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		fmt.Println("Error decoding response:", err)
+	} else if apiURL := os.Getenv("TRANSCRIBE_API_URL"); apiURL != "" {
+		transcriber = extract.OpenAITranscriber{
+			BaseURL: apiURL,
+			APIKey:  os.Getenv("TRANSCRIBE_API_KEY"),
+			Model:   os.Getenv("TRANSCRIBE_MODEL"),
+		}
+	} else {
+		fmt.Println("Warning: no transcriber configured, audio and video links will be skipped")
 		return
 	}
 
-	for i, result := range response.Items {
-		if i >= maxResults {
-			break
-		}
-		// if _, exists := linkSet[result.Link]; !exists {
-		// linkSet[result.Link] = struct{}{}
-		fmt.Println("Ted result : ", result)
-		result.IsTED = true
-		resultsCh <- result
-		// }
+	cacheDir := os.Getenv("TRANSCRIPT_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = "transcripts"
 	}
+	extract.SetTranscriber(extract.CachedTranscriber{Transcriber: transcriber, Dir: cacheDir})
 }
 
 var talkMap = make(map[string]extract.TEDTalk)
@@ -178,6 +134,117 @@ func LoadTEDTalks(filename string) ([]extract.TEDTalk, error) {
 	return tedTalks, nil
 }
 
+// Outcomes of scraping a search result, reported in the source list.
+const (
+	sourceOK              = "ok"
+	sourceEmpty           = "empty"
+	sourceBlockedByRobots = "blocked_by_robots"
+	sourceBlockedByPolicy = "blocked_by_policy"
+	sourceDuplicate       = "duplicate"
+	sourceError           = "error"
+)
+
+type SourceStatus struct {
+	Title  string `json:"title"`
+	Link   string `json:"link"`
+	Status string `json:"status"`
+	Trust  string `json:"trust"`
+	Error  string `json:"error,omitempty"`
+	// Snippet is the provider's summary of the result, if it gave one.
+	Snippet string `json:"snippet,omitempty"`
+	// DuplicateOf links the source a duplicate's content was kept from.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+}
+
+// Citation is a source the answer's in-text citations ([Number]) refer to.
+type Citation struct {
+	Number   int    `json:"number"`
+	Title    string `json:"title"`
+	Link     string `json:"link"`
+	Location string `json:"location,omitempty"`
+	Trust    string `json:"trust"`
+}
+
+type SearchResponse struct {
+	Query string `json:"query"`
+	// Standalone is a follow-up question as it was searched, rewritten to
+	// stand on its own.
+	Standalone string         `json:"standalone,omitempty"`
+	SessionID  string         `json:"session_id"`
+	Answer     string         `json:"answer"`
+	Citations  []Citation     `json:"citations"`
+	Sources    []SourceStatus `json:"sources"`
+	// Debug shows how the query was searched, with debug=true.
+	Debug *rewrite.Plan `json:"debug,omitempty"`
+	// Trace shows the steps of an agentic search.
+	Trace *Trace `json:"trace,omitempty"`
+}
+
+func sourceStatus(result embedstore.Result, documents []embedstore.Document, err error) SourceStatus {
+	status := SourceStatus{Title: result.Title, Link: result.Link, Status: sourceOK, Snippet: result.Snippet}
+	switch {
+	case errors.Is(err, extract.ErrBlockedByRobots):
+		status.Status = sourceBlockedByRobots
+	case err != nil:
+		status.Status = sourceError
+		status.Error = err.Error()
+	case len(documents) == 0:
+		status.Status = sourceEmpty
+	}
+	return status
+}
+
+func formatSources(sources []SourceStatus) string {
+	var sb strings.Builder
+	sb.WriteString("Sources checked :\n")
+	for _, source := range sources {
+		sb.WriteString(fmt.Sprintf("[%s] %s - %s (trust: %s)\n", source.Status, source.Title, source.Link, source.Trust))
+	}
+	return sb.String()
+}
+
+// authorized reports whether r carries the token in the environment
+// variable tokenVar as its bearer token. Without a token every request is
+// refused, unless auth is disabled.
+func authorized(r *http.Request, tokenVar string, disabled bool) bool {
+	if disabled {
+		return true
+	}
+	token := os.Getenv(tokenVar)
+	return token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+// openSessionStore opens the store conversations are kept in.
+func openSessionStore(cfg config.Sessions) (session.Store, error) {
+	ttl := cfg.TTL.Duration
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	switch cfg.Store {
+	case "", "memory":
+		return session.NewMemoryStore(ttl), nil
+	case "file":
+		dir := cfg.Dir
+		if dir == "" {
+			dir = "sessions"
+		}
+		return session.NewFileStore(dir, ttl)
+	}
+	return nil, fmt.Errorf("unknown session store %q", cfg.Store)
+}
+
+func formatPlan(plan rewrite.Plan) string {
+	var sb strings.Builder
+	sb.WriteString("Queries searched :\n")
+	for _, rw := range plan.Queries {
+		sb.WriteString(fmt.Sprintf("[%s] %s\n", rw.Kind, rw.Query))
+	}
+	if plan.Hypothetical != "" {
+		sb.WriteString("Hypothetical answer used for retrieval :\n" + plan.Hypothetical + "\n")
+	}
+	return sb.String()
+}
+
 type LLMRequest struct {
 	Query     string `json:"query"`
 	Context   string `json:"context"`
@@ -238,9 +305,516 @@ func formatResponse(resp *genai.GenerateContentResponse) string {
 
 var totalChunks = 0
 
+// startPodcasts ingests the configured podcast feeds in the background.
+func startPodcasts(cfg config.Podcasts) {
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, option.WithAPIKey(g_Api_Key))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	source, err := podcast.NewSource(cfg, func(result embedstore.Result, documents []embedstore.Document) {
+		ingestDocuments(ctx, client, result, documents)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	go source.Run(ctx)
+}
+
+// importWiki adds every article of a MediaWiki XML dump to the knowledge
+// base, so it can be searched without network access to the wiki. Articles
+// already imported at the same revision are skipped, so an interrupted import
+// can simply be restarted.
+func importWiki(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(path, ".bz2") {
+		reader = bzip2.NewReader(file)
+	}
+
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, option.WithAPIKey(g_Api_Key))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	count := 0
+	err = wiki.ReadDump(reader, func(base string, page wiki.Page) error {
+		result := wiki.Result(page, wiki.IndexURL(base), "wikipedia")
+		documents, err := extract.Scrape(result, nil)
+		if err != nil {
+			return err
+		}
+		ingestDocuments(ctx, client, result, documents)
+		count++
+		if count%1000 == 0 {
+			log.Printf("Imported %d articles", count)
+		}
+		return nil
+	})
+	log.Printf("Imported %d articles from %s", count, path)
+	return err
+}
+
+// importOpinions adds the opinions of a CourtListener bulk download to the
+// knowledge base. Like importWiki, it skips opinions already stored
+// unchanged, so it can be restarted.
+func importOpinions(path string) error {
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, option.WithAPIKey(g_Api_Key))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	count := 0
+	err = caselaw.ReadBulk(path, "https://www.courtlistener.com", func(opinion caselaw.Opinion) error {
+		result := caselaw.Result(opinion, "courtlistener")
+		documents, err := extract.Scrape(result, nil)
+		if err != nil {
+			return err
+		}
+		ingestDocuments(ctx, client, result, documents)
+		count++
+		if count%1000 == 0 {
+			log.Printf("Imported %d opinions", count)
+		}
+		return nil
+	})
+	log.Printf("Imported %d opinions from %s", count, path)
+	return err
+}
+
+// importFile ingests a local file, cited by its file:// link. Local files are
+// only read from here, never for links found by a search.
+func importFile(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	documents, err := extract.ScrapeFile(path)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, option.WithAPIKey(g_Api_Key))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	link := (&url.URL{Scheme: "file", Path: path}).String()
+	result := embedstore.Result{Title: filepath.Base(path), Link: link}
+	ingestDocuments(ctx, client, result, documents)
+	log.Printf("Imported %d documents from %s", len(documents), path)
+	return nil
+}
+
+// The knowledge base calls of ingestDocuments, replaced in tests.
+var (
+	hasContent     = embedstore.HasContent
+	deleteSource   = embedstore.DeleteSource
+	storeDocument  = embedstore.GetGeminiEmbedding
+	setContentHash = embedstore.SetContentHash
+)
+
+// ingestDocuments embeds and stores the documents scraped for a result,
+// unless the same content is already stored for it. The content hash is
+// recorded only once every document is stored, so a source left half
+// embedded, e.g. when the embedding budget runs out, is redone next time.
+func ingestDocuments(ctx context.Context, client *genai.Client, result embedstore.Result, documents []embedstore.Document) {
+	if len(documents) == 0 {
+		return
+	}
+	// Requests finding the same page at once would both replace its chunks.
+	sourceID := result.SourceID()
+	unlock := ingestLocks.lock(sourceID)
+	defer unlock()
+
+	hash := documents[0].Metadata["content_hash"]
+	if hash != "" {
+		stored, err := hasContent(sourceID, hash)
+		if err != nil {
+			log.Printf("Error checking stored content for %s: %v", result.Link, err)
+		} else if stored {
+			log.Printf("Content unchanged, skipping embedding: %s", result.Link)
+			return
+		}
+	}
+	// Without a hash nothing tells a complete source from a partial one,
+	// so it is always replaced.
+	if err := deleteSource(sourceID); err != nil {
+		log.Printf("Error removing outdated chunks for %s: %v", result.Link, err)
+	}
+
+	documents = capDocuments(result.Link, documents)
+
+	complete := true
+	for _, document := range documents {
+		if document.PageContent == "" {
+			continue
+		}
+		document.Metadata = maps.Clone(document.Metadata)
+		delete(document.Metadata, "content_hash")
+		// Generating an embedding for the scraped content
+		err := storeDocument(ctx, client, document, "embedding-001", result.Title, result)
+		if errors.Is(err, quota.ErrExhausted) {
+			return
+		}
+		if err != nil {
+			complete = false
+		}
+	}
+	if hash != "" && complete {
+		if err := setContentHash(sourceID, hash); err != nil {
+			log.Printf("Error recording the content hash of %s: %v", result.Link, err)
+		}
+	}
+}
+
+// keyedMutex serializes work per key.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	waiters int
+}
+
+var ingestLocks = &keyedMutex{locks: map[string]*keyedLock{}}
+
+// lock locks key and returns the function that unlocks it.
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.waiters++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// chunkLink is the link to cite for a chunk. Transcript chunks link to the
+// recording (media_url when the transcript is a separate file) at their
+// start time.
+func chunkLink(chunk embedstore.ChunkData) string {
+	start, err := strconv.ParseFloat(chunk.Metadata["start"], 64)
+	if err != nil {
+		return chunk.Link
+	}
+	link := chunk.Link
+	if mediaURL := chunk.Metadata["media_url"]; mediaURL != "" {
+		link = mediaURL
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	q := u.Query()
+	q.Set("t", strconv.Itoa(int(start)))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func formatTimestamp(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	if d >= time.Hour {
+		return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// rankByTrust drops chunks from domains the policy doesn't allow (they may
+// have been stored before the policy changed), weights the rest's scores by
+// how trusted their domain is and keeps the best limit of them.
+func rankByTrust(chunks []embedstore.ChunkData, policy *trust.Policy, limit int) []embedstore.ChunkData {
+	var ranked []embedstore.ChunkData
+	for _, chunk := range chunks {
+		if !policy.Allowed(chunk.Link) {
+			continue
+		}
+		chunk.Score *= float32(policy.Weight(chunk.Link))
+		ranked = append(ranked, chunk)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+const abstention = "I couldn't find enough relevant, trustworthy sources to answer this reliably. Try rephrasing the question or searching with a different profile.\n"
+
+// Answers are written by generationModel, unless its daily budget is used
+// up; then the retrieved passages are returned after knowledgeBaseOnly.
+const (
+	generationModel   = "gemini-1.5-flash"
+	knowledgeBaseOnly = "The daily answer budget is used up, so here are the most relevant passages from the knowledge base instead:\n\n"
+	answerFailed      = "Sorry, the answer couldn't be generated. Please try again.\n"
+)
+
+// sourceCount is the number of distinct sources chunks come from.
+func sourceCount(chunks []embedstore.ChunkData) int {
+	links := map[string]bool{}
+	for _, chunk := range chunks {
+		links[chunk.Link] = true
+	}
+	return len(links)
+}
+
+// Chunk metadata passed to the model with each paragraph so it can write
+// full references.
+var citationFields = []struct{ key, label string }{
+	{"byline", "Author"},
+	{"published", "Published"},
+	{"journal", "Journal"},
+	{"journal_ref", "Journal reference"},
+	{"doi", "DOI"},
+	{"pmid", "PMID"},
+	{"arxiv_id", "arXiv ID"},
+	{"version", "arXiv version"},
+	{"primary_category", "arXiv category"},
+	{"court", "Court"},
+	{"court_citation", "Court abbreviation"},
+	{"docket_number", "Docket number"},
+	{"decided", "Decided"},
+	{"citation", "Reporter citation"},
+	{"opinion_type", "Opinion"},
+}
+
+// Caps how many documents are embedded per search result, by what they are
+// split into: PDF pages, slides, transcript cue groups, wiki sections, or
+// anything else. "ingest.max_documents" in the config overrides them.
+var maxDocuments = map[string]int{
+	"pages":      500,
+	"slides":     300,
+	"transcript": 300,
+	"sections":   200,
+	"documents":  20,
+}
+
+// capDocuments keeps the first maxDocuments of every kind of document, so
+// an abstract leading a paper's pages or sections doesn't cap them as
+// "documents".
+func capDocuments(link string, documents []embedstore.Document) []embedstore.Document {
+	counts := map[string]int{}
+	var kept []embedstore.Document
+	for _, document := range documents {
+		kind := documentKind(document)
+		counts[kind]++
+		if limit := maxDocuments[kind]; limit > 0 && counts[kind] > limit {
+			continue
+		}
+		kept = append(kept, document)
+	}
+	for kind, count := range counts {
+		if limit := maxDocuments[kind]; limit > 0 && count > limit {
+			log.Printf("Embedding only the first %d of %d %s of %s (ingest.max_documents)", limit, count, kind, link)
+		}
+	}
+	return kept
+}
+
+// documentKind is the maxDocuments key for a document.
+func documentKind(document embedstore.Document) string {
+	switch {
+	case document.Metadata["page"] != "":
+		return "pages"
+	case document.Metadata["slide"] != "":
+		return "slides"
+	case document.Metadata["start"] != "":
+		return "transcript"
+	case document.Metadata["section"] != "":
+		return "sections"
+	}
+	return "documents"
+}
+
+// chunkLocation describes where in its source a chunk came from, for citations.
+func chunkLocation(chunk embedstore.ChunkData) string {
+	switch {
+	case chunk.Metadata["page"] != "":
+		return "p. " + chunk.Metadata["page"]
+	case chunk.Metadata["slide"] != "":
+		return "slide " + chunk.Metadata["slide"]
+	case chunk.Metadata["start"] != "":
+		start, _ := strconv.ParseFloat(chunk.Metadata["start"], 64)
+		end, _ := strconv.ParseFloat(chunk.Metadata["end"], 64)
+		return formatTimestamp(start) + "-" + formatTimestamp(end)
+	case chunk.Metadata["section"] != "":
+		location := "section " + chunk.Metadata["section"]
+		if heading := chunk.Metadata["heading"]; heading != "" {
+			location += " (" + heading + ")"
+		}
+		return location
+	}
+	return ""
+}
+
 func main() {
+	purgeModel := flag.String("purge-embeddings", "", "drop the cached embeddings of a retired model and exit")
+	wikiDump := flag.String("import-wiki", "", "import a MediaWiki XML dump (.xml or .xml.bz2) into the knowledge base and exit")
+	opinions := flag.String("import-opinions", "", "import CourtListener bulk opinion JSON (a file, directory or .tar.gz) into the knowledge base and exit")
+	ingestFile := flag.String("ingest-file", "", "ingest a local document or media file into the knowledge base and exit")
+	mcpStdio := flag.Bool("mcp", false, "serve the search tools over MCP on stdin/stdout instead of HTTP")
+	flag.Parse()
+
+	// Over stdio, stdout carries the MCP messages, so everything else the
+	// service prints goes to stderr.
+	mcpOut := os.Stdout
+	if *mcpStdio {
+		os.Stdout = os.Stderr
+	}
+
+	configPath := os.Getenv("LUCIDSEARCH_CONFIG")
+	if configPath == "" {
+		configPath = "lucidsearch.json"
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	embeddingCachePath := cfg.EmbeddingCache
+	if embeddingCachePath == "" {
+		embeddingCachePath = "embeddings.db"
+	}
+	embeddingCache, err := embedstore.OpenEmbeddingCache(embeddingCachePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer embeddingCache.Close()
+
+	if *purgeModel != "" {
+		count, err := embeddingCache.Purge(*purgeModel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Purged %d cached embeddings for model %s\n", count, *purgeModel)
+		return
+	}
+	embedstore.SetEmbeddingCache(embeddingCache)
+
+	for kind, limit := range cfg.Ingest.MaxDocuments {
+		maxDocuments[kind] = limit
+	}
+
+	quotaState := cfg.Quota.State
+	if quotaState == "" {
+		quotaState = "quota.json"
+	}
+	quotas, err := quota.New(cfg.Quota.Budgets, cfg.Quota.Timezone, quotaState)
+	if err != nil {
+		log.Fatal(err)
+	}
+	quota.SetManager(quotas)
 
 	loadEnvVars()
+	setupTranscriber()
+
+	google := cfg.Providers.Google
+	provider.Register("google", provider.GoogleCSE{
+		APIKey: apiKey, CX: cxID,
+		DateRestrict: google.DateRestrict, Site: google.Site, Language: google.Language, SafeSearch: google.SafeSearch,
+	})
+	provider.Register("ted", provider.GoogleCSE{
+		APIKey: apiKey, CX: cxID, TED: true,
+		DateRestrict: google.DateRestrict, Language: google.Language, SafeSearch: google.SafeSearch,
+	})
+	provider.Register("pubmed", provider.PubMed{APIKey: os.Getenv("NCBI_API_KEY"), FullText: true})
+	provider.Register("arxiv", provider.ArXiv{FullText: cfg.Providers.ArXiv.FullText})
+	provider.Register("wikipedia", provider.MediaWiki{APIURL: cfg.Providers.MediaWiki.APIURL})
+	provider.Register("courtlistener", provider.CourtListener{
+		BaseURL:  cfg.Providers.CourtListener.APIURL,
+		Token:    os.Getenv("COURTLISTENER_TOKEN"),
+		FullText: os.Getenv("COURTLISTENER_TOKEN") != "",
+	})
+
+	profiles, err := profile.Load(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fetcher := extract.NewFetcher(cfg.Scraper.UserAgent, cfg.Scraper.MaxPerHost, cfg.Scraper.MinDelay.Duration)
+	fetcher.AllowPrivate = cfg.Scraper.AllowPrivate
+	extract.SetFetcher(fetcher)
+
+	cacheDir := cfg.Cache.Dir
+	if cacheDir == "" {
+		cacheDir = "cache"
+	}
+	cache, err := extract.NewCache(cacheDir, cfg.Cache.MaxBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	extract.SetCache(cache)
+
+	sessions, err := openSessionStore(cfg.Sessions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dimension := 768
+	fmt.Printf("Embedding dimensions: %d\n", dimension)
+
+	// Setup of Qdrant collection with the dimension. The collection is kept
+	// across requests so ingested podcasts stay searchable.
+	embedstore.SetupQdrantCollection(dimension)
+
+	if *wikiDump != "" {
+		if err := importWiki(*wikiDump); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *opinions != "" {
+		if err := importOpinions(*opinions); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *ingestFile != "" {
+		if err := importFile(*ingestFile); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *mcpStdio {
+		log.Println("Serving MCP on stdin/stdout")
+		if err := mcpServer(profiles).ServeStdio(context.Background(), os.Stdin, mcpOut); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(cfg.Podcasts.Feeds) > 0 {
+		startPodcasts(cfg.Podcasts)
+	}
 
 	// query := flag.String("query", "", "Search query")
 	// flag.Parse()
@@ -256,6 +830,41 @@ func main() {
 			return
 		}
 
+		profileName := r.URL.Query().Get("profile")
+		if profileName == "" {
+			profileName = profile.Default
+		}
+		searchProfile, ok := profiles[profileName]
+		if !ok {
+			http.Error(w, "Unknown profile: "+profileName, http.StatusBadRequest)
+			return
+		}
+		debug, _ := strconv.ParseBool(r.URL.Query().Get("debug"))
+
+		// Follow-up questions continue the conversation they name; other
+		// questions start one.
+		var conversation *session.Session
+		if id := r.URL.Query().Get("session"); id != "" {
+			var err error
+			conversation, err = sessions.Get(id)
+			if errors.Is(err, session.ErrNotFound) {
+				http.Error(w, "Unknown or expired session: "+id, http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error loading session %s: %v", id, err)
+				http.Error(w, "Search failed", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			var err error
+			if conversation, err = session.New(); err != nil {
+				log.Printf("Error starting session: %v", err)
+				http.Error(w, "Search failed", http.StatusInternalServerError)
+				return
+			}
+		}
+
 		// Handling spaces in the query parameter
 		fmt.Println("Before ", query)
 		query = strings.ReplaceAll(query, "+", " ")
@@ -268,112 +877,150 @@ func main() {
 		}
 		defer client.Close()
 
-		fmt.Println("loading json")
-		tedTalks, _ := LoadTEDTalks("new_op.json")
-		dimension := 768
-
-		fmt.Printf("Embedding dimensions: %d\n", dimension)
-
-		// Setup of Qdrant collection with the dimension
-		embedstore.SetupQdrantCollection(dimension)
-
-		// Channel to receive search results and a wait group till evry gets bback
-		resultsCh := make(chan embedstore.Result)
-		var wg sync.WaitGroup
-
-		linkSet := make(map[string]struct{})
-
-		wg.Add(2)
-		go func() {
-			defer wg.Done()
-			GoogleSearch(query, 8, resultsCh, linkSet)
-		}()
-		go func() {
-			defer wg.Done()
-			TedSearch(query, 3, resultsCh, linkSet)
-		}()
-
-		// end recv
-		go func() {
-			wg.Wait()
-			close(resultsCh)
-		}()
-
-		// Process each result from the search results channel
-		var processWg sync.WaitGroup
-		for result := range resultsCh {
-			fmt.Println("Title:", result.Title)
-			fmt.Println("Link:", result.Link)
-			processWg.Add(1)
-			go func(result embedstore.Result) {
-				// Scrape the content from the search result link
-				defer processWg.Done()
-				// content, _ := scrape(result, tedTalks)
-				content, _ := extract.Scrape(result, tedTalks)
-				// fmt.Println("CONTENTTTT : ", document.PageContent)
-				// content := document.PageContent
-				if content != "" {
-					// Generating an embedding for the scraped content
-					embedstore.GetGeminiEmbedding(ctx, client, content, "embedding-001", result.Title, result, false)
-					// print("Embeddingsssqherr LEN ", len(embedding))
-					if err != nil {
-						log.Println("Error getting embedding:", err)
-						return
-					}
-				}
-				return
-			}(result)
+		rewriteQuery := searchProfile.Rewrite
+		if value := r.URL.Query().Get("rewrite"); value != "" {
+			rewriteQuery, _ = strconv.ParseBool(value)
+		}
 
+		agent, _ := strconv.ParseBool(r.URL.Query().Get("agent"))
+		maxHops := cfg.Agent.MaxHops
+		if maxHops <= 0 {
+			maxHops = defaultMaxHops
+		}
+		if n, err := strconv.Atoi(r.URL.Query().Get("max_hops")); err == nil && n > 0 {
+			maxHops = min(n, maxHops)
 		}
-		processWg.Wait()
-		fmt.Printf("Total embeddings : %d\n", totalChunks)
-		fmt.Println()
 
-		// Generate an embedding for the search query
+		pipe := newPipeline(ctx, client, searchProfile)
+		pipe.sessionChunks = conversation.Chunks
+		standalone := query
+		if len(conversation.Turns) > 0 {
+			standalone, err = rewrite.Condense(ctx, pipe.generator(), conversation.History(), query)
+			if err != nil {
+				log.Printf("Searching the follow-up as asked: %v", err)
+			}
+			log.Printf("Standalone question: %s", standalone)
+		}
 
-		queryEmbedding := embedstore.GetGeminiEmbedding(ctx, client, query, "embedding-001", "abc", embedstore.Result{}, true)
+		var (
+			s     string
+			cited []Citation
+			plan  rewrite.Plan
+			trace *Trace
+		)
+		if agent {
+			var steps Trace
+			s, cited, steps, err = pipe.research(standalone, maxHops, rewriteQuery)
+			trace = &steps
+		} else {
+			s, cited, plan, err = pipe.ask(standalone, rewriteQuery, nil)
+		}
+		if errors.Is(err, quota.ErrExhausted) {
+			// Queries asked before are still answered from the embedding
+			// cache; new ones have to wait for the budget to reset.
+			http.Error(w, "The daily embedding budget is used up, try again tomorrow", http.StatusServiceUnavailable)
+			return
+		}
 		if err != nil {
-			log.Fatalf("Error generating query embedding: %v", err)
+			log.Printf("Search failed: %v", err)
+			http.Error(w, "Search failed", http.StatusInternalServerError)
+			return
 		}
-		print("QEMED : ", queryEmbedding)
 
-		// Search for similar embeddings in Qdrant using the query embedding
-		limit := 10
-		var scoreThreshold float32 = 0.6
-		chunkIDs, err := embedstore.SearchQdrant(queryEmbedding, limit, scoreThreshold)
-		if err != nil {
-			log.Fatalf("Error searching Qdrant: %v", err)
+		turn := session.Turn{Query: query, Answer: strings.TrimSuffix(s, "\n---\n")}
+		if standalone != query {
+			turn.Standalone = standalone
 		}
+		conversation.AddTurn(turn, pipe.retrieved)
+		if err := sessions.Save(conversation); err != nil {
+			log.Printf("Error saving session %s: %v", conversation.ID, err)
+		}
+		w.Header().Set("X-Session-ID", conversation.ID)
 
-		// Retrieve the content chunks corresponding to the found chunk IDs
-		chunks, err := embedstore.GetChunks(chunkIDs)
-		if err != nil {
-			log.Fatalf("Error retrieving chunks: %v", err)
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			response := SearchResponse{Query: query, Standalone: turn.Standalone, SessionID: conversation.ID, Answer: s, Citations: cited, Sources: pipe.sources, Trace: trace}
+			if debug && !agent {
+				response.Debug = &plan
+			}
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		w.Write([]byte(s))
+		w.Write([]byte(formatSources(pipe.sources)))
+		if trace != nil {
+			w.Write([]byte(formatTrace(*trace)))
+		} else if debug {
+			w.Write([]byte(formatPlan(plan)))
 		}
 
-		// chunks := chunkToDocuments(c, 8192)
+	})
 
-		fmt.Println("CHONSKKS ", "$$$", chunks)
+	if cfg.Auth.Disabled {
+		log.Println("Serving /v1/chat/completions and /mcp without authentication")
+	}
+	http.HandleFunc("/v1/chat/completions", chatCompletions(profiles, cfg.Auth.Disabled))
+	http.HandleFunc("/v1/models", chatModels(profiles))
 
-		// fmt.Print("CHUNKKSKSKS", chunks)
-		// Combininng the retrieved chunks into a single context string
-		instruction := `You are a helpful AI assistant that helps users answer queries using the provided context. If you cant frame an answer from the context given, copy paste directly from context rather than making up an answer. Please provide a detailed answer to the query below only using the context provided. Include in-text citations like this [1] for each fact or statement at the end of the sentence. At the end of your response, list all sources in a citation section with the format: [citation number] Name - URL.`
+	// MCP over HTTP, for clients with MCP_TOKEN as their bearer token.
+	mcpHandler := mcpServer(profiles)
+	http.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, "MCP_TOKEN", cfg.Auth.Disabled) {
+			http.Error(w, "Unauthorized: set MCP_TOKEN and send it as a bearer token", http.StatusUnauthorized)
+			return
+		}
+		mcpHandler.ServeHTTP(w, r)
+	})
 
-		context := ""
-		for _, chunk := range chunks {
-			if chunk.Text == query {
-				continue
+	// A conversation's turns, or DELETE to end it.
+	// Conversations are read and ended with the chat API key, since their
+	// IDs are all it would take to read someone else's.
+	http.HandleFunc("/session", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, "CHAT_API_KEY", cfg.Auth.Disabled) {
+			http.Error(w, "Send CHAT_API_KEY as a bearer token", http.StatusUnauthorized)
+			return
+		}
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "A session id must be provided", http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			if err := sessions.Delete(id); err != nil {
+				log.Printf("Error deleting session %s: %v", id, err)
+				http.Error(w, "Error deleting session", http.StatusInternalServerError)
+				return
 			}
-			context += "Title of the website where the following paragraph was obtained from -> " + chunk.Title + ". Link of the website -> " + chunk.Link + " . Paragraph -> " + chunk.Text + " . End of that paragraph.\n Starting new paragraph :  \n"
+			w.WriteHeader(http.StatusNoContent)
+			return
 		}
-		llmquery := "INSTRUCTION : " + instruction + ". QUERY : " + query + ". CONTEXT : " + context + "."
-		model := client.GenerativeModel("gemini-1.5-flash")
-		fmt.Print("LLM QUERY FINAL : ", llmquery)
-
-		s := queryLLMTest(ctx, model, client, llmquery, 300)
-		// s := invokeLLMChain(ctx, model, client, chunks, query)
-		w.Write([]byte(s))
+		conversation, err := sessions.Get(id)
+		if errors.Is(err, session.ErrNotFound) {
+			http.Error(w, "Unknown or expired session: "+id, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading session %s: %v", id, err)
+			http.Error(w, "Error loading session", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conversation)
+	})
 
+	// Remaining budgets, for operators. Disabled unless ADMIN_TOKEN is set.
+	http.HandleFunc("/admin/quota", func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("ADMIN_TOKEN")
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.NotFound(w, r)
+			return
+		}
+		usage, resets := quotas.Usage()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Usage    []quota.Usage `json:"usage"`
+			ResetsAt time.Time     `json:"resets_at"`
+		}{usage, resets})
 	})
 
 	log.Println("Starting server on :8080")
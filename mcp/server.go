@@ -0,0 +1,221 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// Protocol revisions the server speaks, latest last. A client asking for
+// another one is offered the latest.
+var protocolVersions = []string{"2024-11-05", "2025-03-26", "2025-06-18"}
+
+// JSON-RPC error codes.
+const (
+	parseError     = -32700
+	invalidRequest = -32600
+	methodNotFound = -32601
+	invalidParams  = -32602
+)
+
+// Tool is a function clients can call. InputSchema is the JSON Schema of
+// its arguments. Call's result is returned to the client as text; an error
+// is reported as a failed call, for the client's model to see.
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"inputSchema"`
+	Call        Handler         `json:"-"`
+}
+
+// Handler runs a tool with the arguments of a call.
+type Handler func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// Server is a Model Context Protocol server offering tools, over stdio
+// (ServeStdio) or HTTP (as an http.Handler).
+type Server struct {
+	name    string
+	version string
+	tools   []Tool
+}
+
+func NewServer(name, version string) *Server {
+	return &Server{name: name, version: version}
+}
+
+func (s *Server) AddTool(tool Tool) {
+	s.tools = append(s.tools, tool)
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type callResult struct {
+	Content []content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// Handle answers one JSON-RPC message. It returns nil for notifications,
+// which get no response.
+func (s *Server) Handle(ctx context.Context, message []byte) []byte {
+	var req request
+	if err := json.Unmarshal(message, &req); err != nil {
+		return encode(response{ID: json.RawMessage("null"), Error: &rpcError{parseError, err.Error()}})
+	}
+	if req.ID == nil {
+		// Notifications, like notifications/initialized, need nothing.
+		return nil
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return encode(response{ID: req.ID, Error: &rpcError{invalidRequest, "not a JSON-RPC 2.0 request"}})
+	}
+	result, rpcErr := s.dispatch(ctx, req)
+	return encode(response{ID: req.ID, Result: result, Error: rpcErr})
+}
+
+func (s *Server) dispatch(ctx context.Context, req request) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &params)
+		version := protocolVersions[len(protocolVersions)-1]
+		if slices.Contains(protocolVersions, params.ProtocolVersion) {
+			version = params.ProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]string{"name": s.name, "version": s.version},
+		}, nil
+
+	case "ping":
+		return map[string]any{}, nil
+
+	case "tools/list":
+		return map[string]any{"tools": s.tools}, nil
+
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{invalidParams, err.Error()}
+		}
+		for _, tool := range s.tools {
+			if tool.Name != params.Name {
+				continue
+			}
+			arguments := params.Arguments
+			if len(arguments) == 0 {
+				arguments = json.RawMessage("{}")
+			}
+			text, err := tool.Call(ctx, arguments)
+			if err != nil {
+				return callResult{Content: []content{{"text", err.Error()}}, IsError: true}, nil
+			}
+			return callResult{Content: []content{{"text", text}}}, nil
+		}
+		return nil, &rpcError{invalidParams, "unknown tool: " + params.Name}
+	}
+	return nil, &rpcError{methodNotFound, "method not found: " + req.Method}
+}
+
+func encode(resp response) []byte {
+	resp.JSONRPC = "2.0"
+	data, err := json.Marshal(resp)
+	if err != nil {
+		data, _ = json.Marshal(response{JSONRPC: "2.0", ID: resp.ID, Error: &rpcError{-32603, err.Error()}})
+	}
+	return data
+}
+
+// ServeStdio reads newline-delimited messages from r and writes the
+// responses to w until r is closed. Requests are handled concurrently, as
+// tool calls can take a while.
+func (s *Server) ServeStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		message := bytes.TrimSpace(scanner.Bytes())
+		if len(message) == 0 {
+			continue
+		}
+		message = bytes.Clone(message)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply := s.Handle(ctx, message)
+			if reply == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if _, err := w.Write(append(reply, '\n')); err != nil {
+				log.Printf("Error writing MCP response: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading MCP messages: %v", err)
+	}
+	return nil
+}
+
+// ServeHTTP is the streamable HTTP transport without streaming: each POSTed
+// message is answered with a JSON response, and notifications with 202
+// Accepted. There are no server-initiated messages, so GET is refused.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	message, err := io.ReadAll(io.LimitReader(r.Body, 16*1024*1024))
+	if err != nil {
+		http.Error(w, "Error reading request", http.StatusBadRequest)
+		return
+	}
+	reply := s.Handle(r.Context(), message)
+	if reply == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(reply)
+}
@@ -0,0 +1,172 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func testServer() *Server {
+	s := NewServer("lucidsearch", "1.0")
+	s.AddTool(Tool{
+		Name:        "echo",
+		Description: "Returns its text.",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{"text":{"type":"string"}}}`),
+		Call: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+			var args struct {
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal(arguments, &args); err != nil || args.Text == "" {
+				return "", errors.New("a text must be provided")
+			}
+			return args.Text, nil
+		},
+	})
+	return s
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		// want is the expected response, or "" for none.
+		want string
+		// prefix is set when the end of the message depends on the Go
+		// version.
+		prefix bool
+	}{
+		{
+			name:    "initialize with a known version",
+			message: `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05"}}`,
+			want:    `{"jsonrpc":"2.0","id":1,"result":{"capabilities":{"tools":{}},"protocolVersion":"2024-11-05","serverInfo":{"name":"lucidsearch","version":"1.0"}}}`,
+		},
+		{
+			name:    "initialize with an unknown version",
+			message: `{"jsonrpc":"2.0","id":"a","method":"initialize","params":{"protocolVersion":"1999-01-01"}}`,
+			want:    `{"jsonrpc":"2.0","id":"a","result":{"capabilities":{"tools":{}},"protocolVersion":"2025-06-18","serverInfo":{"name":"lucidsearch","version":"1.0"}}}`,
+		},
+		{
+			name:    "notification",
+			message: `{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		},
+		{
+			name:    "ping",
+			message: `{"jsonrpc":"2.0","id":2,"method":"ping"}`,
+			want:    `{"jsonrpc":"2.0","id":2,"result":{}}`,
+		},
+		{
+			name:    "tools/list",
+			message: `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`,
+			want:    `{"jsonrpc":"2.0","id":3,"result":{"tools":[{"name":"echo","description":"Returns its text.","inputSchema":{"type":"object","properties":{"text":{"type":"string"}}}}]}}`,
+		},
+		{
+			name:    "tools/call",
+			message: `{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"echo","arguments":{"text":"hello"}}}`,
+			want:    `{"jsonrpc":"2.0","id":4,"result":{"content":[{"type":"text","text":"hello"}]}}`,
+		},
+		{
+			name:    "failing tool call",
+			message: `{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"echo"}}`,
+			want:    `{"jsonrpc":"2.0","id":5,"result":{"content":[{"type":"text","text":"a text must be provided"}],"isError":true}}`,
+		},
+		{
+			name:    "unknown tool",
+			message: `{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"shout"}}`,
+			want:    `{"jsonrpc":"2.0","id":6,"error":{"code":-32602,"message":"unknown tool: shout"}}`,
+		},
+		{
+			name:    "malformed params",
+			message: `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":[1]}`,
+			want:    `{"jsonrpc":"2.0","id":7,"error":{"code":-32602,"message":"json: cannot unmarshal array`,
+			prefix:  true,
+		},
+		{
+			name:    "unknown method",
+			message: `{"jsonrpc":"2.0","id":8,"method":"resources/list"}`,
+			want:    `{"jsonrpc":"2.0","id":8,"error":{"code":-32601,"message":"method not found: resources/list"}}`,
+		},
+		{
+			name:    "not JSON-RPC 2.0",
+			message: `{"jsonrpc":"1.0","id":9,"method":"ping"}`,
+			want:    `{"jsonrpc":"2.0","id":9,"error":{"code":-32600,"message":"not a JSON-RPC 2.0 request"}}`,
+		},
+		{
+			name:    "no method",
+			message: `{"jsonrpc":"2.0","id":10}`,
+			want:    `{"jsonrpc":"2.0","id":10,"error":{"code":-32600,"message":"not a JSON-RPC 2.0 request"}}`,
+		},
+		{
+			name:    "parse error",
+			message: `{"jsonrpc":`,
+			want:    `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"unexpected end of JSON input"}}`,
+		},
+	}
+	s := testServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.Handle(context.Background(), []byte(tt.message))
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("got response %s to a notification", got)
+				}
+				return
+			}
+			if string(got) != tt.want && !(tt.prefix && strings.HasPrefix(string(got), tt.want)) {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeStdio(t *testing.T) {
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"ping"}`,
+		``,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"echo","arguments":{"text":"hi"}}}`,
+	}, "\n")
+	var output strings.Builder
+	if err := testServer().ServeStdio(context.Background(), strings.NewReader(input), &output); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests are handled concurrently, so responses may come in any order.
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	sort.Strings(lines)
+	want := []string{
+		`{"jsonrpc":"2.0","id":1,"result":{}}`,
+		`{"jsonrpc":"2.0","id":2,"result":{"content":[{"type":"text","text":"hi"}]}}`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got responses\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "request", method: http.MethodPost, body: `{"jsonrpc":"2.0","id":1,"method":"ping"}`, wantStatus: http.StatusOK, wantBody: `{"jsonrpc":"2.0","id":1,"result":{}}`},
+		{name: "notification", method: http.MethodPost, body: `{"jsonrpc":"2.0","method":"notifications/initialized"}`, wantStatus: http.StatusAccepted},
+		{name: "GET", method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed, wantBody: "Use POST\n"},
+	}
+	s := testServer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(tt.method, "/mcp", strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus || w.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q, want %d %q", w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
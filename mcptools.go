@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"lucidsearch/dedupe"
+	"lucidsearch/embedstore"
+	"lucidsearch/extract"
+	"lucidsearch/mcp"
+	"lucidsearch/profile"
+	"lucidsearch/provider"
+	"lucidsearch/quota"
+	"net/url"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// Tools the MCP server offers: the steps of a search, to be combined by
+// the client's agent, and the whole search as one.
+
+const queryProfileSchema = `{
+	"type": "object",
+	"properties": {
+		"query": {"type": "string", "description": "The question or search query."},
+		"profile": {"type": "string", "description": "Search profile: default, legal, medical, scientific or one from the config."}%s
+	},
+	"required": ["query"]
+}`
+
+// mcpServer builds the MCP server for the search pipeline.
+func mcpServer(profiles map[string]*profile.Profile) *mcp.Server {
+	server := mcp.NewServer("lucidsearch", "0.1")
+	tools := mcpTools{profiles: profiles}
+
+	server.AddTool(mcp.Tool{
+		Name:        "web_search",
+		Description: "Search the web with the profile's providers and return the results (title, link, snippet), deduplicated and filtered by its trust policy. Nothing is fetched or stored.",
+		InputSchema: json.RawMessage(fmt.Sprintf(queryProfileSchema, `,
+		"max_results": {"type": "integer", "description": "Results per provider, at most the profile's own limit."}`)),
+		Call: tools.webSearch,
+	})
+	server.AddTool(mcp.Tool{
+		Name:        "retrieve_chunks",
+		Description: "Find the passages in the knowledge base most relevant to a query, ranked by similarity and source trust, with their source and citation details. Only what has been ingested is searched.",
+		InputSchema: json.RawMessage(fmt.Sprintf(queryProfileSchema, `,
+		"limit": {"type": "integer", "description": "Passages to return, at most 10."}`)),
+		Call: tools.retrieveChunks,
+	})
+	server.AddTool(mcp.Tool{
+		Name:        "ingest_url",
+		Description: "Fetch a page, PDF, office document or media file, extract its text and add it to the knowledge base, so retrieve_chunks can find it.",
+		InputSchema: json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"url": {"type": "string", "description": "Link to ingest."},
+		"title": {"type": "string", "description": "Title to cite it by, if the page doesn't give one."},
+		"profile": {"type": "string", "description": "Search profile whose trust policy applies."}
+	},
+	"required": ["url"]
+}`),
+		Call: tools.ingestURL,
+	})
+	server.AddTool(mcp.Tool{
+		Name:        "answer_with_citations",
+		Description: "Search the web, ingest the results and answer the question from the most relevant passages, citing them as [n]. Returns the answer, its citations and the sources checked.",
+		InputSchema: json.RawMessage(fmt.Sprintf(queryProfileSchema, `,
+		"rewrite": {"type": "boolean", "description": "Search rewrites of the query too, and a hypothetical answer."}`)),
+		Call: tools.answerWithCitations,
+	})
+	return server
+}
+
+type mcpTools struct {
+	profiles map[string]*profile.Profile
+}
+
+func (t mcpTools) profile(name string) (*profile.Profile, error) {
+	if name == "" {
+		name = profile.Default
+	}
+	p, ok := t.profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile: %s", name)
+	}
+	return p, nil
+}
+
+// run gives fn a pipeline for the profile and returns its result as JSON.
+func (t mcpTools) run(ctx context.Context, profileName string, fn func(p *pipeline) (any, error)) (string, error) {
+	searchProfile, err := t.profile(profileName)
+	if err != nil {
+		return "", err
+	}
+	client, err := genai.NewClient(ctx, option.WithAPIKey(g_Api_Key))
+	if err != nil {
+		return "", fmt.Errorf("error creating Gemini client: %v", err)
+	}
+	defer client.Close()
+
+	result, err := fn(newPipeline(ctx, client, searchProfile))
+	if errors.Is(err, quota.ErrExhausted) {
+		return "", fmt.Errorf("the daily budget is used up, try again tomorrow: %v", err)
+	}
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	return string(data), err
+}
+
+func (t mcpTools) webSearch(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Query      string `json:"query"`
+		Profile    string `json:"profile"`
+		MaxResults int    `json:"max_results"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil || args.Query == "" {
+		return "", errors.New("a query must be provided")
+	}
+	searchProfile, err := t.profile(args.Profile)
+	if err != nil {
+		return "", err
+	}
+
+	type result struct {
+		Title    string `json:"title"`
+		Link     string `json:"link"`
+		Snippet  string `json:"snippet,omitempty"`
+		Provider string `json:"provider"`
+		Trust    string `json:"trust"`
+	}
+	results := []result{}
+	merger := dedupe.NewMerger()
+	for _, ref := range searchProfile.Providers {
+		prov, _ := provider.Get(ref.Name)
+		maxResults := ref.MaxResults
+		if args.MaxResults > 0 {
+			maxResults = min(args.MaxResults, maxResults)
+		}
+		found, err := prov.Search(ctx, args.Query, maxResults)
+		if err != nil {
+			log.Printf("Error searching %s: %v", ref.Name, err)
+			continue
+		}
+		for _, r := range found {
+			r, ok := merger.Add(r)
+			if !ok || !searchProfile.Policy.Allowed(r.Link) {
+				continue
+			}
+			results = append(results, result{Title: r.Title, Link: r.Link, Snippet: r.Snippet, Provider: ref.Name, Trust: searchProfile.Policy.Tier(r.Link)})
+		}
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	return string(data), err
+}
+
+func (t mcpTools) retrieveChunks(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Query   string `json:"query"`
+		Profile string `json:"profile"`
+		Limit   int    `json:"limit"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil || args.Query == "" {
+		return "", errors.New("a query must be provided")
+	}
+	return t.run(ctx, args.Profile, func(p *pipeline) (any, error) {
+		chunks, err := p.retrieve(args.Query, "")
+		if err != nil {
+			return nil, err
+		}
+		if args.Limit > 0 && len(chunks) > args.Limit {
+			chunks = chunks[:args.Limit]
+		}
+		type passage struct {
+			Title    string            `json:"title"`
+			Link     string            `json:"link"`
+			Location string            `json:"location,omitempty"`
+			Score    float32           `json:"score"`
+			Trust    string            `json:"trust"`
+			Text     string            `json:"text"`
+			Metadata map[string]string `json:"metadata,omitempty"`
+		}
+		passages := []passage{}
+		for _, chunk := range chunks {
+			passages = append(passages, passage{
+				Title: chunk.Title, Link: chunkLink(chunk), Location: chunkLocation(chunk),
+				Score: chunk.Score, Trust: p.profile.Policy.Tier(chunk.Link),
+				Text: chunk.Text, Metadata: chunk.Metadata,
+			})
+		}
+		return passages, nil
+	})
+}
+
+func (t mcpTools) ingestURL(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		URL     string `json:"url"`
+		Title   string `json:"title"`
+		Profile string `json:"profile"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil || args.URL == "" {
+		return "", errors.New("a url must be provided")
+	}
+	if u, err := url.Parse(args.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("not an http(s) link: %s", args.URL)
+	}
+	return t.run(ctx, args.Profile, func(p *pipeline) (any, error) {
+		result := embedstore.Result{Title: args.Title, Link: args.URL}
+		if !p.profile.Policy.Allowed(result.Link) {
+			return SourceStatus{Title: result.Title, Link: result.Link, Status: sourceBlockedByPolicy, Trust: p.profile.Policy.Tier(result.Link)}, nil
+		}
+		documents, err := extract.Scrape(result, p.tedTalks)
+		status := sourceStatus(result, documents, err)
+		status.Trust = p.profile.Policy.Tier(result.Link)
+		ingestDocuments(ctx, p.client, result, documents)
+		return status, nil
+	})
+}
+
+func (t mcpTools) answerWithCitations(ctx context.Context, arguments json.RawMessage) (string, error) {
+	var args struct {
+		Query   string `json:"query"`
+		Profile string `json:"profile"`
+		Rewrite *bool  `json:"rewrite"`
+	}
+	if err := json.Unmarshal(arguments, &args); err != nil || args.Query == "" {
+		return "", errors.New("a query must be provided")
+	}
+	return t.run(ctx, args.Profile, func(p *pipeline) (any, error) {
+		rewriteQuery := p.profile.Rewrite
+		if args.Rewrite != nil {
+			rewriteQuery = *args.Rewrite
+		}
+		answer, cited, _, err := p.ask(args.Query, rewriteQuery, nil)
+		if err != nil {
+			return nil, err
+		}
+		if cited == nil {
+			cited = []Citation{}
+		}
+		return struct {
+			Answer    string         `json:"answer"`
+			Citations []Citation     `json:"citations"`
+			Sources   []SourceStatus `json:"sources"`
+		}{strings.TrimSuffix(answer, "\n---\n"), cited, p.sources}, nil
+	})
+}
@@ -0,0 +1,165 @@
+package podcast
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"golang.org/x/net/html/charset"
+)
+
+type Episode struct {
+	Show        string
+	Title       string
+	GUID        string
+	Link        string
+	Published   time.Time
+	MediaURL    string
+	MediaType   string
+	Transcripts []Transcript
+}
+
+// Transcript is a Podcasting 2.0 <podcast:transcript> reference.
+type Transcript struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Language string `xml:"language,attr"`
+	Rel      string `xml:"rel,attr"`
+}
+
+// Timed formats come first since they let citations link into the audio.
+var transcriptPreference = map[string]int{
+	"text/vtt":             0,
+	"application/x-subrip": 1,
+	"application/srt":      1,
+	"application/json":     2,
+	"text/html":            3,
+	"text/plain":           4,
+}
+
+type feedXML struct {
+	// RSS
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+
+	// Atom
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title     string `xml:"title"`
+	GUID      string `xml:"guid"`
+	Link      string `xml:"link"`
+	PubDate   string `xml:"pubDate"`
+	Enclosure struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	Transcripts []Transcript `xml:"transcript"`
+}
+
+type atomEntry struct {
+	Title     string `xml:"title"`
+	ID        string `xml:"id"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	Links     []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"link"`
+	Transcripts []Transcript `xml:"transcript"`
+}
+
+// ParseFeed reads the episodes of an RSS 2.0 or Atom podcast feed.
+func ParseFeed(body []byte) (string, []Episode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+
+	var feed feedXML
+	if err := decoder.Decode(&feed); err != nil {
+		return "", nil, fmt.Errorf("error parsing feed: %v", err)
+	}
+
+	var episodes []Episode
+	show := strings.TrimSpace(feed.Channel.Title)
+	for _, item := range feed.Channel.Items {
+		episodes = append(episodes, Episode{
+			Show:        show,
+			Title:       strings.TrimSpace(item.Title),
+			GUID:        strings.TrimSpace(item.GUID),
+			Link:        strings.TrimSpace(item.Link),
+			Published:   parseDate(item.PubDate),
+			MediaURL:    item.Enclosure.URL,
+			MediaType:   item.Enclosure.Type,
+			Transcripts: item.Transcripts,
+		})
+	}
+
+	if show == "" {
+		show = strings.TrimSpace(feed.Title)
+	}
+	for _, entry := range feed.Entries {
+		episode := Episode{
+			Show:        show,
+			Title:       strings.TrimSpace(entry.Title),
+			GUID:        strings.TrimSpace(entry.ID),
+			Published:   parseDate(entry.Published),
+			Transcripts: entry.Transcripts,
+		}
+		if episode.Published.IsZero() {
+			episode.Published = parseDate(entry.Updated)
+		}
+		for _, link := range entry.Links {
+			switch link.Rel {
+			case "enclosure":
+				episode.MediaURL, episode.MediaType = link.Href, link.Type
+			case "", "alternate":
+				episode.Link = link.Href
+			}
+		}
+		episodes = append(episodes, episode)
+	}
+
+	for i := range episodes {
+		if episodes[i].GUID == "" {
+			episodes[i].GUID = episodes[i].MediaURL
+		}
+		// Without a guid or enclosure, the link alone is often the show's
+		// page, shared by every episode.
+		if episodes[i].GUID == "" && episodes[i].Title != "" {
+			episodes[i].GUID = episodes[i].Link + " " + episodes[i].Title
+		}
+		sort.SliceStable(episodes[i].Transcripts, func(a, b int) bool {
+			return transcriptRank(episodes[i].Transcripts[a]) < transcriptRank(episodes[i].Transcripts[b])
+		})
+	}
+	return show, episodes, nil
+}
+
+func transcriptRank(t Transcript) int {
+	if rank, ok := transcriptPreference[strings.ToLower(t.Type)]; ok {
+		return rank
+	}
+	return len(transcriptPreference)
+}
+
+func parseDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := dateparse.ParseAny(s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,222 @@
+package podcast
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"lucidsearch/config"
+	"lucidsearch/embedstore"
+	"lucidsearch/extract"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	defaultRefresh     = 6 * time.Hour
+	defaultMaxEpisodes = 10
+	defaultState       = "podcasts.json"
+)
+
+// Ingester stores the documents extracted from one episode.
+type Ingester func(result embedstore.Result, documents []embedstore.Document)
+
+// Source keeps the knowledge base up to date with the configured podcast
+// feeds, ingesting each new episode's transcript (or, without one, the
+// transcribed audio) once. The episodes ingested are saved to the state file
+// so restarts don't transcribe them again.
+type Source struct {
+	cfg    config.Podcasts
+	ingest Ingester
+	path   string
+	seen   map[string]bool
+}
+
+type state struct {
+	Episodes []string `json:"episodes"`
+}
+
+// NewSource creates a source for cfg's feeds, reading the episodes already
+// ingested from cfg.State if it exists.
+func NewSource(cfg config.Podcasts, ingest Ingester) (*Source, error) {
+	s := &Source{
+		cfg:    cfg,
+		ingest: ingest,
+		path:   cfg.State,
+		seen:   map[string]bool{},
+	}
+	if s.path == "" {
+		s.path = defaultState
+	}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("error reading podcast state %s: %v", s.path, err)
+	}
+	for _, guid := range saved.Episodes {
+		s.seen[guid] = true
+	}
+	return s, nil
+}
+
+// Run refreshes every feed right away and then on the configured schedule
+// until ctx is cancelled.
+func (s *Source) Run(ctx context.Context) {
+	refresh := s.cfg.Refresh.Duration
+	if refresh <= 0 {
+		refresh = defaultRefresh
+	}
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	for {
+		s.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Source) Refresh(ctx context.Context) {
+	for _, feed := range s.cfg.Feeds {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.refreshFeed(ctx, feed); err != nil {
+			log.Printf("Error refreshing podcast feed %s: %v", feed.URL, err)
+		}
+	}
+}
+
+func (s *Source) refreshFeed(ctx context.Context, feed config.PodcastFeed) error {
+	fetchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	page, err := extract.Fetch(fetchCtx, feed.URL)
+	cancel()
+	if err != nil {
+		return err
+	}
+	show, episodes, err := ParseFeed(page.Body)
+	if err != nil {
+		return err
+	}
+	if feed.Name != "" {
+		show = feed.Name
+	}
+
+	maxEpisodes := feed.MaxEpisodes
+	if maxEpisodes <= 0 {
+		maxEpisodes = defaultMaxEpisodes
+	}
+	for i, episode := range episodes {
+		if i >= maxEpisodes || ctx.Err() != nil {
+			break
+		}
+		if episode.GUID == "" {
+			log.Printf("Skipping podcast episode without a guid, enclosure or title in %s", feed.URL)
+			continue
+		}
+		if s.seen[episode.GUID] {
+			continue
+		}
+		episode.Show = show
+		if err := s.ingestEpisode(episode); err != nil {
+			log.Printf("Error ingesting podcast episode %q: %v", episode.Title, err)
+			continue
+		}
+		s.seen[episode.GUID] = true
+		if err := s.save(); err != nil {
+			log.Printf("Error saving podcast state: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *Source) save() error {
+	saved := state{Episodes: make([]string, 0, len(s.seen))}
+	for guid := range s.seen {
+		saved.Episodes = append(saved.Episodes, guid)
+	}
+	sort.Strings(saved.Episodes)
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".podcasts-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *Source) ingestEpisode(episode Episode) error {
+	var documents []embedstore.Document
+	for _, transcript := range episode.Transcripts {
+		docs, err := extract.Scrape(embedstore.Result{Title: episode.Title, Link: transcript.URL}, nil)
+		if err != nil {
+			log.Printf("Error fetching transcript %s: %v", transcript.URL, err)
+			continue
+		}
+		if len(docs) > 0 {
+			documents = docs
+			break
+		}
+	}
+	if len(documents) == 0 && episode.MediaURL != "" {
+		docs, err := extract.Scrape(embedstore.Result{Title: episode.Title, Link: episode.MediaURL}, nil)
+		if err != nil {
+			return err
+		}
+		documents = docs
+	}
+	if len(documents) == 0 {
+		log.Printf("No transcript or audio available for podcast episode %q", episode.Title)
+		return nil
+	}
+
+	for i := range documents {
+		if documents[i].Metadata == nil {
+			documents[i].Metadata = map[string]string{}
+		}
+		metadata := documents[i].Metadata
+		metadata["source"] = "podcast"
+		metadata["show"] = episode.Show
+		metadata["episode"] = episode.Title
+		metadata["media_url"] = episode.MediaURL
+		if !episode.Published.IsZero() {
+			metadata["published"] = episode.Published.Format(time.RFC3339)
+		}
+	}
+
+	link := episode.Link
+	if link == "" {
+		link = episode.MediaURL
+	}
+	log.Printf("Ingesting %d documents from podcast episode %q", len(documents), episode.Title)
+	// Episodes often all link to the show's page, so they are stored by
+	// their enclosure or guid instead.
+	id := episode.MediaURL
+	if id == "" {
+		id = episode.GUID
+	}
+	s.ingest(embedstore.Result{Title: episode.Show + " - " + episode.Title, Link: link, ID: id}, documents)
+	return nil
+}
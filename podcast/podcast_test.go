@@ -0,0 +1,203 @@
+package podcast
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"lucidsearch/config"
+	"lucidsearch/embedstore"
+	"lucidsearch/extract"
+)
+
+// readFixture reads a file from testdata, pointing its {{server}} links at
+// server.
+func readFixture(t *testing.T, name, server string) []byte {
+	t.Helper()
+	body, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(strings.ReplaceAll(string(body), "{{server}}", server))
+}
+
+func TestParseFeedRSS(t *testing.T) {
+	show, episodes, err := ParseFeed(readFixture(t, "feed.xml", "https://soil.example"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if show != "Soil Matters" {
+		t.Errorf("got show %q", show)
+	}
+	if len(episodes) != 5 {
+		t.Fatalf("got %d episodes, want 5", len(episodes))
+	}
+
+	first := episodes[0]
+	if first.Title != "Cover crops" || first.GUID != "soil-matters-12" || first.MediaURL != "https://soil.example/audio/12.mp3" {
+		t.Errorf("got episode %+v", first)
+	}
+	if want := time.Date(2024, 9, 3, 6, 0, 0, 0, time.UTC); !first.Published.Equal(want) {
+		t.Errorf("got published %v, want %v", first.Published, want)
+	}
+	if len(first.Transcripts) != 2 || first.Transcripts[0].Type != "text/vtt" {
+		t.Errorf("got transcripts %+v, want the VTT one first", first.Transcripts)
+	}
+
+	// Without a guid, the enclosure identifies the episode.
+	if trailer := episodes[2]; trailer.GUID != "https://soil.example/audio/trailer.mp3" {
+		t.Errorf("got trailer GUID %q, want its enclosure", trailer.GUID)
+	}
+	// Without either, the link and title do, as the link is often the
+	// show's page.
+	if questions := episodes[3]; questions.GUID != "https://soil.example/show Listener questions" {
+		t.Errorf("got GUID %q, want the link and title", questions.GUID)
+	}
+	if notes := episodes[4]; notes.GUID != "" {
+		t.Errorf("got GUID %q for an episode with nothing to identify it", notes.GUID)
+	}
+}
+
+func TestParseFeedAtom(t *testing.T) {
+	show, episodes, err := ParseFeed(readFixture(t, "feed.atom", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if show != "Field Notes" || len(episodes) != 1 {
+		t.Fatalf("got show %q with %d episodes", show, len(episodes))
+	}
+	episode := episodes[0]
+	if episode.Link != "https://fieldnotes.example/7" || episode.MediaURL != "https://fieldnotes.example/7.ogg" || episode.MediaType != "audio/ogg" {
+		t.Errorf("got links %q, %q (%s)", episode.Link, episode.MediaURL, episode.MediaType)
+	}
+	if episode.Published.IsZero() {
+		t.Error("published should fall back to updated")
+	}
+	if len(episode.Transcripts) != 1 || episode.Transcripts[0].URL != "https://fieldnotes.example/7.srt" {
+		t.Errorf("got transcripts %+v", episode.Transcripts)
+	}
+}
+
+// fakeTranscriber transcribes any media as the same single cue.
+type fakeTranscriber struct{}
+
+func (fakeTranscriber) Transcribe(ctx context.Context, path string) ([]extract.Cue, error) {
+	return []extract.Cue{{Start: 0, End: 5 * time.Second, Text: "Transcribed audio."}}, nil
+}
+
+func TestRefresh(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests = map[string]int{}
+	)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/feed.xml":
+			w.Header().Set("Content-Type", "application/rss+xml")
+			w.Write(readFixture(t, "feed.xml", server.URL))
+		case r.URL.Path == "/transcripts/12.vtt":
+			w.Header().Set("Content-Type", "text/vtt")
+			w.Write(readFixture(t, "12.vtt", server.URL))
+		case strings.HasPrefix(r.URL.Path, "/audio/"):
+			w.Header().Set("Content-Type", "audio/mpeg")
+			w.Write([]byte("ID3"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := extract.NewFetcher(extract.DefaultUserAgent, 4, 0)
+	fetcher.AllowPrivate = true
+	extract.SetFetcher(fetcher)
+	extract.SetTranscriber(fakeTranscriber{})
+	defer extract.SetTranscriber(nil)
+
+	ingested := map[string]embedstore.Result{}
+	documents := map[string][]embedstore.Document{}
+	cfg := config.Podcasts{
+		Feeds: []config.PodcastFeed{{URL: server.URL + "/feed.xml", Name: "Soil Matters Weekly", MaxEpisodes: 2}},
+		State: t.TempDir() + "/podcasts.json",
+	}
+	ingest := func(result embedstore.Result, docs []embedstore.Document) {
+		ingested[result.SourceID()] = result
+		documents[result.SourceID()] = docs
+	}
+	source, err := NewSource(cfg, ingest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.Refresh(context.Background())
+
+	if len(ingested) != 2 {
+		t.Fatalf("got %d episodes ingested, want the 2 newest", len(ingested))
+	}
+
+	// Both episodes link to the show's page, so they are stored by their
+	// enclosures.
+	coverCrops := server.URL + "/audio/12.mp3"
+	result, ok := ingested[coverCrops]
+	if !ok {
+		t.Fatalf("episode not stored under its enclosure, got %v", ingested)
+	}
+	if result.Link != server.URL+"/show" || result.Title != "Soil Matters Weekly - Cover crops" {
+		t.Errorf("got result %+v", result)
+	}
+	docs := documents[coverCrops]
+	if len(docs) == 0 || !strings.Contains(docs[0].PageContent, "cover crops and nitrogen") {
+		t.Fatalf("got documents %+v, want the VTT transcript", docs)
+	}
+	metadata := docs[0].Metadata
+	for key, want := range map[string]string{
+		"source":    "podcast",
+		"show":      "Soil Matters Weekly",
+		"episode":   "Cover crops",
+		"media_url": coverCrops,
+		"published": "2024-09-03T06:00:00Z",
+		"format":    "vtt",
+		"start":     "1",
+	} {
+		if metadata[key] != want {
+			t.Errorf("metadata %s: got %q, want %q", key, metadata[key], want)
+		}
+	}
+	if requests["/transcripts/12.txt"] != 0 {
+		t.Error("the plain text transcript was fetched although the VTT one worked")
+	}
+	if requests["/audio/12.mp3"] != 0 {
+		t.Error("audio was downloaded although there was a transcript")
+	}
+
+	// The missing transcript falls back to transcribing the audio.
+	noTill := documents[server.URL+"/audio/11.mp3"]
+	if len(noTill) != 1 || noTill[0].PageContent != "Transcribed audio." || noTill[0].Metadata["format"] != "mp3" {
+		t.Errorf("got documents %+v, want the transcribed audio", noTill)
+	}
+
+	// Episodes already ingested are skipped on the next refresh.
+	before := requests["/transcripts/12.vtt"]
+	ingested = map[string]embedstore.Result{}
+	source.Refresh(context.Background())
+	if len(ingested) != 0 || requests["/transcripts/12.vtt"] != before {
+		t.Errorf("episodes ingested again: %v", ingested)
+	}
+
+	// So are they after a restart.
+	restarted, err := NewSource(cfg, ingest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.Refresh(context.Background())
+	if len(ingested) != 0 || requests["/audio/11.mp3"] != 1 {
+		t.Errorf("episodes ingested again after a restart: %v", ingested)
+	}
+}
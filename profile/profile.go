@@ -0,0 +1,153 @@
+package profile
+
+import (
+	"fmt"
+	"lucidsearch/config"
+	"lucidsearch/provider"
+	"lucidsearch/trust"
+	"slices"
+)
+
+// Default is the profile used when a request doesn't name one.
+const Default = "default"
+
+// Retrieval score below which chunks are left out, for profiles that don't
+// set min_score.
+const defaultMinScore = 0.6
+
+// Profile bundles everything that differs between kinds of search: where
+// results come from, which domains are trusted, how the answer is written
+// and cited, and when to abstain rather than answer.
+type Profile struct {
+	Name          string
+	Providers     []config.ProfileProvider
+	Policy        *trust.Policy
+	Instructions  string
+	CitationStyle string
+	Rewrite       bool
+	MinScore      float32
+	MinSources    int
+}
+
+var citationStyles = map[string]string{
+	"numeric":  "At the end of your response, list all sources in a citation section with the format: [citation number] Name - URL (trust tier), followed by the location (for example p. 14 or 2:03-3:10) when the paragraph has one.",
+	"bluebook": "At the end of your response, list all sources in a citation section in Bluebook style, each prefixed with its citation number: for cases [citation number] Case Name, Reporter Citation (Court Year), using the case's reporter citation and the court as abbreviated in citations (omit the court for the U.S. Supreme Court), or the docket number and full decision date when there is no reporter citation; for other sources Author, Title (Year), URL. Add the trust tier in parentheses and the pinpoint location when the paragraph has one.",
+	"apa":      "At the end of your response, list all sources in a citation section in APA style, each prefixed with its citation number: [citation number] Author. (Year). Title. Journal or site name. DOI or URL (trust tier), followed by the location when the paragraph has one. Cite arXiv papers as preprints with their arXiv ID and version (for example arXiv:1706.03762v7). Leave out the author or year when the paragraph doesn't give them.",
+}
+
+var builtin = map[string]config.Profile{
+	Default: {
+		Providers: []config.ProfileProvider{{Name: "google", MaxResults: 8}, {Name: "ted", MaxResults: 3}},
+	},
+	"legal": {
+		Providers: []config.ProfileProvider{{Name: "courtlistener", MaxResults: 5}, {Name: "google", MaxResults: 5}},
+		Trust: config.Trust{
+			Weights: map[string]float64{
+				".gov": 1.3, "supremecourt.gov": 1.5, "uscourts.gov": 1.5, "law.cornell.edu": 1.4,
+				"courtlistener.com": 1.3, "justia.com": 1.1, "findlaw.com": 1.0,
+			},
+		},
+		Instructions:  "Answer as a legal research assistant. Quote the controlling language of statutes and opinions, name the court and year of every case you rely on, and point out when authorities conflict or may have been superseded. Do not give legal advice.",
+		CitationStyle: "bluebook",
+		MinScore:      0.65,
+		MinSources:    2,
+	},
+	"medical": {
+		Providers: []config.ProfileProvider{{Name: "pubmed", MaxResults: 5}, {Name: "google", MaxResults: 5}},
+		Trust: config.Trust{
+			Weights: map[string]float64{
+				".gov": 1.3, "nih.gov": 1.5, "cdc.gov": 1.5, "who.int": 1.5, "fda.gov": 1.4,
+				"cochranelibrary.com": 1.5, "nejm.org": 1.4, "thelancet.com": 1.4, "bmj.com": 1.4,
+				".edu": 1.2, "mayoclinic.org": 1.2, "pubmed.ncbi.nlm.nih.gov": 1.5,
+			},
+		},
+		Instructions: "Answer as a careful medical information assistant. Prefer clinical guidelines and systematic reviews over single studies, say how strong the evidence is, and give doses only exactly as the sources state them. Remind the reader to consult a clinician before acting on the answer.",
+		MinScore:     0.7,
+		MinSources:   2,
+	},
+	"scientific": {
+		Providers: []config.ProfileProvider{{Name: "arxiv", MaxResults: 5}, {Name: "pubmed", MaxResults: 3}, {Name: "google", MaxResults: 5}},
+		Trust: config.Trust{
+			Weights: map[string]float64{
+				".edu": 1.2, ".gov": 1.2, "nature.com": 1.3, "science.org": 1.3, "ncbi.nlm.nih.gov": 1.3,
+				"arxiv.org": 1.1,
+			},
+		},
+		Instructions:  "Answer as a scientific research assistant. Distinguish peer-reviewed results from preprints, report sample sizes, effect sizes and uncertainties when the sources give them, and say where the evidence is contested.",
+		CitationStyle: "apa",
+		MinScore:      0.65,
+		MinSources:    1,
+	},
+}
+
+// Load builds the profiles requests can select: the built-in ones, replaced
+// or extended by those in cfg. The top-level trust policy's denied domains
+// apply to every profile, and its allowed domains to every profile that
+// doesn't list its own. Its weights only apply to the default profile, unless
+// the config defines a default profile itself.
+func Load(cfg *config.Config) (map[string]*Profile, error) {
+	definitions := map[string]config.Profile{}
+	for name, definition := range builtin {
+		definitions[name] = definition
+	}
+	defaultProfile := definitions[Default]
+	defaultProfile.Trust.Weights = cfg.Trust.Weights
+	definitions[Default] = defaultProfile
+	for name, definition := range cfg.Profiles {
+		definitions[name] = definition
+	}
+
+	profiles := map[string]*Profile{}
+	for name, definition := range definitions {
+		definition.Trust.Deny = append(slices.Clip(cfg.Trust.Deny), definition.Trust.Deny...)
+		if len(definition.Trust.Allow) == 0 {
+			definition.Trust.Allow = cfg.Trust.Allow
+		}
+		p, err := newProfile(name, definition)
+		if err != nil {
+			return nil, err
+		}
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+func newProfile(name string, definition config.Profile) (*Profile, error) {
+	if len(definition.Providers) == 0 {
+		return nil, fmt.Errorf("profile %s has no providers", name)
+	}
+	for _, ref := range definition.Providers {
+		if _, ok := provider.Get(ref.Name); !ok {
+			return nil, fmt.Errorf("profile %s uses unknown provider %q (known: %v)", name, ref.Name, provider.Names())
+		}
+	}
+
+	style := definition.CitationStyle
+	if style == "" {
+		style = "numeric"
+	}
+	if _, ok := citationStyles[style]; !ok {
+		return nil, fmt.Errorf("profile %s uses unknown citation style %q", name, style)
+	}
+
+	minScore := definition.MinScore
+	if minScore == 0 {
+		minScore = defaultMinScore
+	}
+
+	return &Profile{
+		Name:          name,
+		Providers:     definition.Providers,
+		Policy:        trust.New(definition.Trust),
+		Instructions:  definition.Instructions,
+		CitationStyle: style,
+		Rewrite:       definition.Rewrite,
+		MinScore:      float32(minScore),
+		MinSources:    definition.MinSources,
+	}, nil
+}
+
+// CitationInstruction tells the model how to write the citation section.
+func (p *Profile) CitationInstruction() string {
+	return citationStyles[p.CitationStyle]
+}
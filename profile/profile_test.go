@@ -0,0 +1,74 @@
+package profile
+
+import (
+	"context"
+	"testing"
+
+	"lucidsearch/config"
+	"lucidsearch/embedstore"
+	"lucidsearch/provider"
+)
+
+type noResults struct{}
+
+func (noResults) Search(ctx context.Context, query string, maxResults int) ([]embedstore.Result, error) {
+	return nil, nil
+}
+
+func TestLoadTopLevelTrust(t *testing.T) {
+	for _, name := range []string{"google", "ted", "pubmed", "arxiv", "courtlistener"} {
+		provider.Register(name, noResults{})
+	}
+	profiles, err := Load(&config.Config{
+		Trust: config.Trust{
+			Allow:   []string{"gov", "edu", "example.org"},
+			Deny:    []string{"spam.example.org"},
+			Weights: map[string]float64{"example.org": 1.5},
+		},
+		Profiles: map[string]config.Profile{
+			"nutrition": {
+				Providers: []config.ProfileProvider{{Name: "google", MaxResults: 5}},
+				Trust:     config.Trust{Allow: []string{"eatright.org"}, Deny: []string{"ads.eatright.org"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		profile string
+		link    string
+		allowed bool
+		weight  float64
+	}{
+		{profile: Default, link: "https://example.org/a", allowed: true, weight: 1.5},
+		{profile: Default, link: "https://spam.example.org/a", allowed: false},
+		{profile: Default, link: "https://blog.example.com/a", allowed: false},
+		// Built-in profiles keep their weights but take the allow and deny
+		// lists.
+		{profile: "medical", link: "https://www.nih.gov/a", allowed: true, weight: 1.5},
+		{profile: "medical", link: "https://example.org/a", allowed: true, weight: 1},
+		{profile: "medical", link: "https://spam.example.org/a", allowed: false},
+		{profile: "medical", link: "https://blog.example.com/a", allowed: false},
+		// A profile's own allow list replaces the top-level one; both deny
+		// lists apply.
+		{profile: "nutrition", link: "https://www.eatright.org/a", allowed: true, weight: 1},
+		{profile: "nutrition", link: "https://ads.eatright.org/a", allowed: false},
+		{profile: "nutrition", link: "https://example.org/a", allowed: false},
+		{profile: "nutrition", link: "https://spam.example.org/a", allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile+" "+tt.link, func(t *testing.T) {
+			policy := profiles[tt.profile].Policy
+			if got := policy.Allowed(tt.link); got != tt.allowed {
+				t.Errorf("Allowed = %v, want %v", got, tt.allowed)
+			}
+			if tt.allowed {
+				if got := policy.Weight(tt.link); got != tt.weight {
+					t.Errorf("Weight = %v, want %v", got, tt.weight)
+				}
+			}
+		})
+	}
+}
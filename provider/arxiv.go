@@ -0,0 +1,184 @@
+package provider
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"lucidsearch/embedstore"
+	"lucidsearch/extract"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const arxivAPIURL = "https://export.arxiv.org/api/query"
+
+// arXiv IDs are either new style (2101.00001) or old style (hep-th/9901001),
+// followed by the version.
+var arxivID = regexp.MustCompile(`(?:abs|pdf)/(.+?)(v\d+)?$`)
+
+// ArXiv searches arXiv preprints through its Atom query API. Results carry
+// the abstract as a document and, with FullText, the pages of the PDF.
+type ArXiv struct {
+	BaseURL  string // defaults to the arXiv query API
+	FullText bool
+	Client   *http.Client
+}
+
+type arxivFeed struct {
+	Entries []arxivEntry `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+type arxivEntry struct {
+	ID        string `xml:"http://www.w3.org/2005/Atom id"`
+	Title     text   `xml:"http://www.w3.org/2005/Atom title"`
+	Summary   text   `xml:"http://www.w3.org/2005/Atom summary"`
+	Published string `xml:"http://www.w3.org/2005/Atom published"`
+	Authors   []struct {
+		Name string `xml:"http://www.w3.org/2005/Atom name"`
+	} `xml:"http://www.w3.org/2005/Atom author"`
+	Links []struct {
+		Href  string `xml:"href,attr"`
+		Type  string `xml:"type,attr"`
+		Title string `xml:"title,attr"`
+	} `xml:"http://www.w3.org/2005/Atom link"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"http://www.w3.org/2005/Atom category"`
+	PrimaryCategory struct {
+		Term string `xml:"term,attr"`
+	} `xml:"http://arxiv.org/schemas/atom primary_category"`
+	DOI        string `xml:"http://arxiv.org/schemas/atom doi"`
+	JournalRef string `xml:"http://arxiv.org/schemas/atom journal_ref"`
+}
+
+func (a ArXiv) Search(ctx context.Context, query string, maxResults int) ([]embedstore.Result, error) {
+	base := a.BaseURL
+	if base == "" {
+		base = arxivAPIURL
+	}
+	params := url.Values{
+		"search_query": {arxivQuery(query)},
+		"max_results":  {strconv.Itoa(maxResults)},
+		"sortBy":       {"relevance"},
+	}
+	body, err := get(ctx, a.Client, base+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("error searching arXiv: %v", err)
+	}
+
+	var feed arxivFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("error parsing arXiv response: %v", err)
+	}
+
+	var results []embedstore.Result
+	for _, entry := range feed.Entries {
+		if result, ok := arxivResult(entry); ok {
+			results = append(results, result)
+		}
+	}
+
+	if a.FullText {
+		var wg sync.WaitGroup
+		for i := range results {
+			wg.Add(1)
+			go func(result *embedstore.Result) {
+				defer wg.Done()
+				result.Documents = append(result.Documents, arxivPages(*result)...)
+			}(&results[i])
+		}
+		wg.Wait()
+	}
+	return results, nil
+}
+
+// arxivQuery requires every word of the query to appear somewhere in the
+// paper's metadata.
+func arxivQuery(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		word = strings.Trim(word, `"():`)
+		if word != "" {
+			terms = append(terms, "all:"+word)
+		}
+	}
+	return strings.Join(terms, " AND ")
+}
+
+func arxivResult(entry arxivEntry) (embedstore.Result, bool) {
+	match := arxivID.FindStringSubmatch(entry.ID)
+	if match == nil || entry.Summary == "" {
+		return embedstore.Result{}, false
+	}
+	id, version := match[1], match[2]
+
+	var authors, categories []string
+	for _, author := range entry.Authors {
+		authors = append(authors, strings.TrimSpace(author.Name))
+	}
+	for _, category := range entry.Categories {
+		categories = append(categories, category.Term)
+	}
+
+	metadata := map[string]string{
+		"source":           "arxiv",
+		"title":            string(entry.Title),
+		"byline":           strings.Join(authors, ", "),
+		"published":        entry.Published,
+		"arxiv_id":         id,
+		"version":          version,
+		"categories":       strings.Join(categories, ", "),
+		"primary_category": entry.PrimaryCategory.Term,
+		"doi":              strings.TrimSpace(entry.DOI),
+		"journal_ref":      strings.TrimSpace(entry.JournalRef),
+	}
+	for _, link := range entry.Links {
+		if link.Title == "pdf" || link.Type == "application/pdf" {
+			metadata["pdf_url"] = link.Href
+		}
+	}
+
+	return embedstore.Result{
+		Title: string(entry.Title),
+		Link:  "https://arxiv.org/abs/" + id + version,
+		Documents: []embedstore.Document{{
+			PageContent: string(entry.Summary),
+			Metadata:    metadata,
+		}},
+	}, true
+}
+
+// arxivPages downloads and extracts the PDF of a result, tagging its pages
+// with the paper's metadata. Failures only cost the full text.
+func arxivPages(result embedstore.Result) []embedstore.Document {
+	metadata := result.Documents[0].Metadata
+	pdfURL := metadata["pdf_url"]
+	if pdfURL == "" {
+		return nil
+	}
+	// arXiv asks automated clients to use the export mirror.
+	if u, err := url.Parse(pdfURL); err == nil && u.Host == "arxiv.org" {
+		u.Host = "export.arxiv.org"
+		pdfURL = u.String()
+	}
+
+	pages, err := extract.Scrape(embedstore.Result{Title: result.Title, Link: pdfURL}, nil)
+	if err != nil {
+		log.Printf("Error fetching arXiv full text %s: %v", pdfURL, err)
+		return nil
+	}
+	for _, page := range pages {
+		for key, value := range metadata {
+			if page.Metadata[key] == "" {
+				page.Metadata[key] = value
+			}
+		}
+		page.Metadata["source"] = "arxiv"
+	}
+	return pages
+}
@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"lucidsearch/extract"
+)
+
+// arxivServer serves the recorded Atom response in testdata, with its PDF
+// links pointing back at the server, and the paper's PDF.
+func arxivServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/query":
+			query := r.URL.Query()
+			if query.Get("search_query") != "all:sparse AND all:attention" || query.Get("max_results") != "2" {
+				t.Errorf("unexpected query parameters: %s", r.URL.RawQuery)
+			}
+			body, err := os.ReadFile("testdata/arxiv_query.xml")
+			if err != nil {
+				t.Error(err)
+			}
+			w.Header().Set("Content-Type", "application/atom+xml")
+			w.Write([]byte(strings.ReplaceAll(string(body), "{{server}}", server.URL)))
+		case "/pdf/2401.01234v2":
+			body, err := os.ReadFile("testdata/arxiv_2401.01234v2.pdf")
+			if err != nil {
+				t.Error(err)
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return server
+}
+
+func TestArXivSearch(t *testing.T) {
+	server := arxivServer(t)
+	defer server.Close()
+
+	results, err := ArXiv{BaseURL: server.URL + "/api/query"}.Search(context.Background(), `"sparse" attention`, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+
+	result := results[0]
+	if result.Title != "Linear-Time Sparse Attention for Long Documents" {
+		t.Errorf("got title %q", result.Title)
+	}
+	if result.Link != "https://arxiv.org/abs/2401.01234v2" {
+		t.Errorf("got link %q", result.Link)
+	}
+	if len(result.Documents) != 1 {
+		t.Fatalf("got %d documents, want the abstract only", len(result.Documents))
+	}
+	abstract := result.Documents[0]
+	if abstract.PageContent != "We present a sparse attention mechanism whose cost grows linearly with the input length." {
+		t.Errorf("got abstract %q", abstract.PageContent)
+	}
+	for key, want := range map[string]string{
+		"source":           "arxiv",
+		"byline":           "Mei Tanaka, Jonas Weber",
+		"published":        "2024-01-03T10:02:45Z",
+		"arxiv_id":         "2401.01234",
+		"version":          "v2",
+		"categories":       "cs.CL, cs.LG",
+		"primary_category": "cs.CL",
+		"doi":              "10.48550/arXiv.2401.01234",
+		"journal_ref":      "Proc. ACL 2024, pp. 101-112",
+		"pdf_url":          server.URL + "/pdf/2401.01234v2",
+	} {
+		if abstract.Metadata[key] != want {
+			t.Errorf("metadata %s: got %q, want %q", key, abstract.Metadata[key], want)
+		}
+	}
+
+	old := results[1].Documents[0].Metadata
+	if old["arxiv_id"] != "hep-th/9901001" || old["version"] != "v1" {
+		t.Errorf("got old-style ID %q version %q", old["arxiv_id"], old["version"])
+	}
+}
+
+func TestArXivFullText(t *testing.T) {
+	server := arxivServer(t)
+	defer server.Close()
+
+	fetcher := extract.NewFetcher(extract.DefaultUserAgent, 4, 0)
+	fetcher.AllowPrivate = true
+	extract.SetFetcher(fetcher)
+
+	results, err := ArXiv{BaseURL: server.URL + "/api/query", FullText: true}.Search(context.Background(), "sparse attention", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	if len(results[1].Documents) != 1 {
+		t.Errorf("got %d documents for a paper without a PDF link, want the abstract only", len(results[1].Documents))
+	}
+
+	documents := results[0].Documents
+	if len(documents) != 3 {
+		t.Fatalf("got %d documents, want the abstract and 2 pages", len(documents))
+	}
+	page := documents[2]
+	if page.PageContent != "2 Experiments. We train on 40B tokens." {
+		t.Errorf("got page text %q", page.PageContent)
+	}
+	// The PDF has no title or author of its own, so the paper's fill in.
+	for key, want := range map[string]string{
+		"source":   "arxiv",
+		"format":   "pdf",
+		"page":     "2",
+		"title":    "Linear-Time Sparse Attention for Long Documents",
+		"byline":   "Mei Tanaka, Jonas Weber",
+		"arxiv_id": "2401.01234",
+		"version":  "v2",
+	} {
+		if page.Metadata[key] != want {
+			t.Errorf("metadata %s: got %q, want %q", key, page.Metadata[key], want)
+		}
+	}
+}
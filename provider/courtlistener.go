@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"lucidsearch/caselaw"
+	"lucidsearch/embedstore"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const courtListenerAPIURL = "https://www.courtlistener.com/api/rest/v4"
+
+// CourtListener searches case law through a CourtListener-compatible REST
+// API. Results carry the search snippets as a document or, with FullText,
+// the text of each opinion in the case (majority, concurrences, dissents).
+// Fetching opinions needs an API token.
+type CourtListener struct {
+	BaseURL  string // the API root, defaults to CourtListener's v4 API
+	Token    string
+	FullText bool
+	Client   *http.Client
+}
+
+func (c CourtListener) baseURL() string {
+	if c.BaseURL == "" {
+		return courtListenerAPIURL
+	}
+	return strings.TrimRight(c.BaseURL, "/")
+}
+
+// site is the root opinion links are relative to.
+func (c CourtListener) site() string {
+	u, err := url.Parse(c.baseURL())
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (c CourtListener) header() http.Header {
+	if c.Token == "" {
+		return nil
+	}
+	return http.Header{"Authorization": {"Token " + c.Token}}
+}
+
+func (c CourtListener) Search(ctx context.Context, query string, maxResults int) ([]embedstore.Result, error) {
+	params := url.Values{
+		"q":        {query},
+		"type":     {"o"},
+		"order_by": {"score desc"},
+	}
+	body, err := getWithHeader(ctx, c.Client, c.baseURL()+"/search/?"+params.Encode(), c.header())
+	if err != nil {
+		return nil, fmt.Errorf("error searching CourtListener: %v", err)
+	}
+	var response struct {
+		Results []json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error decoding CourtListener search response: %v", err)
+	}
+
+	var hits []caselaw.Opinion
+	for _, raw := range response.Results {
+		if len(hits) >= maxResults {
+			break
+		}
+		hit, err := caselaw.ParseOpinion(raw, c.site())
+		if err != nil {
+			return nil, err
+		}
+		if hit.Link != "" {
+			hits = append(hits, hit)
+		}
+	}
+
+	results := make([]embedstore.Result, len(hits))
+	var wg sync.WaitGroup
+	for i, hit := range hits {
+		results[i] = caselaw.Result(hit, "courtlistener")
+		if !c.FullText || len(hit.SubOpinionIDs) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(result *embedstore.Result, hit caselaw.Opinion) {
+			defer wg.Done()
+			if documents := c.opinions(ctx, hit); len(documents) > 0 {
+				result.Documents = documents
+			}
+		}(&results[i], hit)
+	}
+	wg.Wait()
+
+	var found []embedstore.Result
+	for _, result := range results {
+		if len(result.Documents) > 0 {
+			found = append(found, result)
+		}
+	}
+	return found, nil
+}
+
+// opinions fetches the text of every opinion in a case. Failures only cost
+// the full text; the snippets remain.
+func (c CourtListener) opinions(ctx context.Context, hit caselaw.Opinion) []embedstore.Document {
+	var documents []embedstore.Document
+	for _, id := range hit.SubOpinionIDs {
+		link := c.baseURL() + "/opinions/" + strconv.FormatInt(id, 10) + "/"
+		body, err := getWithHeader(ctx, c.Client, link, c.header())
+		if err != nil {
+			log.Printf("Error fetching opinion %s: %v", link, err)
+			return nil
+		}
+		opinion, err := caselaw.ParseOpinion(body, c.site())
+		if err != nil {
+			log.Printf("Error fetching opinion %s: %v", link, err)
+			return nil
+		}
+		if opinion.Text == "" {
+			continue
+		}
+		opinion.Fill(hit)
+		documents = append(documents, caselaw.Result(opinion, "courtlistener").Documents...)
+	}
+	return documents
+}
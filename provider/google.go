@@ -0,0 +1,158 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"lucidsearch/embedstore"
+	"lucidsearch/quota"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const googleSearchURL = "https://www.googleapis.com/customsearch/v1"
+
+const (
+	// Results per request, and the last result the API returns
+	// (start+num-1 may not pass it).
+	googlePageSize   = 10
+	googleMaxResults = 100
+)
+
+// GoogleCSE searches the web with a Google Programmable Search Engine. With
+// TED set it looks for TED talks instead, marking the results so Scrape reads
+// their transcripts.
+type GoogleCSE struct {
+	APIKey  string
+	CX      string
+	TED     bool
+	BaseURL string // defaults to the Custom Search JSON API
+
+	DateRestrict string // e.g. d7, m6 or y1 for the past week, six months or year
+	Site         string // only return results from this site
+	Language     string // e.g. lang_en
+	SafeSearch   bool
+
+	Client *http.Client
+}
+
+type googleItem struct {
+	Title       string `json:"title"`
+	Link        string `json:"link"`
+	Snippet     string `json:"snippet"`
+	DisplayLink string `json:"displayLink"`
+	Mime        string `json:"mime"`
+	PageMap     struct {
+		MetaTags []map[string]any `json:"metatags"`
+	} `json:"pagemap"`
+}
+
+// Meta tags kept from the page map, by the metadata key they fill.
+var googleMetaTags = []struct{ key, tag string }{
+	{"published", "article:published_time"},
+	{"published", "og:updated_time"},
+	{"byline", "author"},
+	{"byline", "article:author"},
+	{"site_name", "og:site_name"},
+	{"description", "og:description"},
+	{"description", "description"},
+}
+
+func (g GoogleCSE) Search(ctx context.Context, query string, maxResults int) ([]embedstore.Result, error) {
+	if g.TED {
+		query = "TED Talk " + query
+	}
+	var results []embedstore.Result
+	for start := 1; len(results) < maxResults; {
+		num := min(googlePageSize, maxResults-len(results), googleMaxResults-start+1)
+		if num <= 0 {
+			break
+		}
+		items, more, err := g.page(ctx, query, start, num)
+		if err != nil {
+			if len(results) > 0 {
+				log.Printf("Error fetching Google results from %d on: %v", start, err)
+				break
+			}
+			return nil, err
+		}
+		for _, item := range items {
+			results = append(results, g.result(item))
+		}
+		start += len(items)
+		if !more || len(items) == 0 {
+			break
+		}
+	}
+	return results, nil
+}
+
+// page fetches num results from start on, reporting whether there are more.
+func (g GoogleCSE) page(ctx context.Context, query string, start, num int) ([]googleItem, bool, error) {
+	params := url.Values{
+		"q":     {query},
+		"key":   {g.APIKey},
+		"cx":    {g.CX},
+		"start": {strconv.Itoa(start)},
+		"num":   {strconv.Itoa(num)},
+	}
+	if g.DateRestrict != "" {
+		params.Set("dateRestrict", g.DateRestrict)
+	}
+	if g.Site != "" {
+		params.Set("siteSearch", g.Site)
+		params.Set("siteSearchFilter", "i")
+	}
+	if g.Language != "" {
+		params.Set("lr", g.Language)
+	}
+	if g.SafeSearch {
+		params.Set("safe", "active")
+	}
+	log.Printf("Google search %q from %d", query, start)
+
+	// Every request is billed, including those for further pages.
+	if err := quota.Spend("google", 1); err != nil {
+		return nil, false, err
+	}
+	base := g.BaseURL
+	if base == "" {
+		base = googleSearchURL
+	}
+	body, err := get(ctx, g.Client, base+"?"+params.Encode())
+	if err != nil {
+		return nil, false, fmt.Errorf("error making request to Google Search API: %v", err)
+	}
+	var response struct {
+		Items   []googleItem `json:"items"`
+		Queries struct {
+			NextPage []json.RawMessage `json:"nextPage"`
+		} `json:"queries"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, false, fmt.Errorf("error decoding response: %v", err)
+	}
+	return response.Items, len(response.Queries.NextPage) > 0, nil
+}
+
+func (g GoogleCSE) result(item googleItem) embedstore.Result {
+	metadata := map[string]string{}
+	for _, tags := range item.PageMap.MetaTags {
+		for _, field := range googleMetaTags {
+			if value, ok := tags[field.tag].(string); ok && value != "" && metadata[field.key] == "" {
+				metadata[field.key] = value
+			}
+		}
+	}
+	return embedstore.Result{
+		Title:       item.Title,
+		Link:        item.Link,
+		IsTED:       g.TED,
+		Snippet:     item.Snippet,
+		DisplayLink: item.DisplayLink,
+		Mime:        item.Mime,
+		Metadata:    metadata,
+	}
+}
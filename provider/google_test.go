@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// googleServer serves total results as Custom Search does, recording the
+// start and num of every request.
+func googleServer(t *testing.T, total int, requests *[][2]int) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		start, _ := strconv.Atoi(query.Get("start"))
+		num, _ := strconv.Atoi(query.Get("num"))
+		*requests = append(*requests, [2]int{start, num})
+		if num > googlePageSize || start+num-1 > googleMaxResults {
+			http.Error(w, `{"error":{"code":400,"message":"Request contains an invalid argument."}}`, http.StatusBadRequest)
+			return
+		}
+
+		var response struct {
+			Items   []map[string]any `json:"items"`
+			Queries map[string]any   `json:"queries"`
+		}
+		for i := start; i < start+num && i <= total; i++ {
+			response.Items = append(response.Items, map[string]any{
+				"title":   fmt.Sprintf("Result %d", i),
+				"link":    fmt.Sprintf("https://example.com/%d", i),
+				"snippet": fmt.Sprintf("Snippet %d", i),
+				"pagemap": map[string]any{"metatags": []map[string]any{{"author": "Ann Author", "og:site_name": "Example"}}},
+			})
+		}
+		if start+num <= total && start+num <= googleMaxResults {
+			response.Queries = map[string]any{"nextPage": []map[string]any{{"startIndex": start + num}}}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func TestGoogleCSEPaging(t *testing.T) {
+	tests := []struct {
+		name         string
+		total        int // results the engine has
+		maxResults   int
+		wantRequests [][2]int
+		wantResults  int
+	}{
+		{name: "one page", total: 200, maxResults: 8, wantRequests: [][2]int{{1, 8}}, wantResults: 8},
+		{name: "partial last page", total: 200, maxResults: 25, wantRequests: [][2]int{{1, 10}, {11, 10}, {21, 5}}, wantResults: 25},
+		{name: "no more results", total: 15, maxResults: 40, wantRequests: [][2]int{{1, 10}, {11, 10}}, wantResults: 15},
+		{
+			name: "all the API returns", total: 200, maxResults: 150,
+			wantRequests: [][2]int{{1, 10}, {11, 10}, {21, 10}, {31, 10}, {41, 10}, {51, 10}, {61, 10}, {71, 10}, {81, 10}, {91, 10}},
+			wantResults:  100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests [][2]int
+			server := googleServer(t, tt.total, &requests)
+			defer server.Close()
+
+			results, err := GoogleCSE{BaseURL: server.URL, APIKey: "test-key", CX: "cx"}.Search(context.Background(), "soil health", tt.maxResults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(requests, tt.wantRequests) {
+				t.Errorf("got requests (start, num) %v, want %v", requests, tt.wantRequests)
+			}
+			if len(results) != tt.wantResults {
+				t.Fatalf("got %d results, want %d", len(results), tt.wantResults)
+			}
+			last := results[len(results)-1]
+			if want := fmt.Sprintf("https://example.com/%d", tt.wantResults); last.Link != want {
+				t.Errorf("got last link %q, want %q", last.Link, want)
+			}
+			if last.Snippet != fmt.Sprintf("Snippet %d", tt.wantResults) || last.Metadata["byline"] != "Ann Author" || last.Metadata["site_name"] != "Example" {
+				t.Errorf("got snippet %q and metadata %v", last.Snippet, last.Metadata)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lucidsearch/embedstore"
+	"lucidsearch/wiki"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const wikipediaAPIURL = "https://en.wikipedia.org/w/api.php"
+
+// MediaWiki searches a MediaWiki site such as Wikipedia through its action
+// API. Results link to the revision they were read from and carry one
+// document per section.
+type MediaWiki struct {
+	APIURL string // the wiki's api.php, defaults to English Wikipedia
+	Client *http.Client
+}
+
+func (m MediaWiki) apiURL() string {
+	if m.APIURL == "" {
+		return wikipediaAPIURL
+	}
+	return m.APIURL
+}
+
+func (m MediaWiki) Search(ctx context.Context, query string, maxResults int) ([]embedstore.Result, error) {
+	params := url.Values{
+		"action":        {"query"},
+		"list":          {"search"},
+		"srsearch":      {query},
+		"srlimit":       {strconv.Itoa(maxResults)},
+		"srprop":        {""},
+		"format":        {"json"},
+		"formatversion": {"2"},
+	}
+	body, err := get(ctx, m.Client, m.apiURL()+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("error searching MediaWiki: %v", err)
+	}
+	var search struct {
+		Query struct {
+			Search []struct {
+				PageID int64 `json:"pageid"`
+			} `json:"search"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal(body, &search); err != nil {
+		return nil, fmt.Errorf("error decoding MediaWiki search response: %v", err)
+	}
+	if len(search.Query.Search) == 0 {
+		return nil, nil
+	}
+
+	var ids []string
+	for _, hit := range search.Query.Search {
+		ids = append(ids, strconv.FormatInt(hit.PageID, 10))
+	}
+	pages, err := m.pages(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	indexURL := strings.TrimSuffix(m.apiURL(), "api.php") + "index.php"
+	// Keep the search ranking; the API returns pages in its own order.
+	var results []embedstore.Result
+	for _, id := range ids {
+		page, ok := pages[id]
+		if !ok {
+			continue
+		}
+		if result := wiki.Result(page, indexURL, "mediawiki"); len(result.Documents) > 0 {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// pages fetches the current revision of each page, by page ID.
+func (m MediaWiki) pages(ctx context.Context, ids []string) (map[string]wiki.Page, error) {
+	params := url.Values{
+		"action":        {"query"},
+		"prop":          {"revisions"},
+		"rvprop":        {"ids|timestamp|content"},
+		"rvslots":       {"main"},
+		"pageids":       {strings.Join(ids, "|")},
+		"format":        {"json"},
+		"formatversion": {"2"},
+	}
+	body, err := get(ctx, m.Client, m.apiURL()+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("error fetching MediaWiki pages: %v", err)
+	}
+	var response struct {
+		Query struct {
+			Pages []struct {
+				PageID    int64  `json:"pageid"`
+				Title     string `json:"title"`
+				Revisions []struct {
+					RevID     int64  `json:"revid"`
+					Timestamp string `json:"timestamp"`
+					Slots     struct {
+						Main struct {
+							Content string `json:"content"`
+						} `json:"main"`
+					} `json:"slots"`
+				} `json:"revisions"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error decoding MediaWiki pages: %v", err)
+	}
+
+	pages := map[string]wiki.Page{}
+	for _, page := range response.Query.Pages {
+		if len(page.Revisions) == 0 {
+			continue
+		}
+		revision := page.Revisions[0]
+		pages[strconv.FormatInt(page.PageID, 10)] = wiki.Page{
+			Title:      page.Title,
+			RevisionID: revision.RevID,
+			Timestamp:  revision.Timestamp,
+			Text:       revision.Slots.Main.Content,
+		}
+	}
+	return pages, nil
+}
@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"lucidsearch/embedstore"
+	"lucidsearch/extract"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxResponseBytes = 50 << 20
+
+// Provider finds results for a query, e.g. a web search API or a document
+// collection.
+type Provider interface {
+	Search(ctx context.Context, query string, maxResults int) ([]embedstore.Result, error)
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{}
+)
+
+// Register makes p available to search profiles under name.
+func Register(name string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[name] = p
+}
+
+func Get(name string) (Provider, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := providers[name]
+	return p, ok
+}
+
+// Names lists the registered providers.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// get reads the body of a successful GET request.
+func get(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	return getWithHeader(ctx, client, url, nil)
+}
+
+// getWithHeader is get with extra request headers, e.g. for authentication.
+func getWithHeader(ctx context.Context, client *http.Client, url string, header http.Header) ([]byte, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	// APIs such as Wikimedia's ask clients to identify themselves.
+	req.Header.Set("User-Agent", extract.DefaultUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request failed with status code: %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+}
+
+// text collects the character data of an XML element and everything nested
+// in it, dropping inline markup such as <i> or <sup>.
+type text string
+
+func (t *text) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var sb strings.Builder
+	depth := 0
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch token := token.(type) {
+		case xml.CharData:
+			sb.Write(token)
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				*t = text(strings.Join(strings.Fields(sb.String()), " "))
+				return nil
+			}
+			depth--
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"lucidsearch/embedstore"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const eutilsURL = "https://eutils.ncbi.nlm.nih.gov/entrez/eutils"
+
+// PubMed searches the biomedical literature through the NCBI E-utilities.
+// Results carry the abstract as a document, plus the open-access full text
+// from PubMed Central, one document per section, when FullText is set.
+type PubMed struct {
+	BaseURL  string // defaults to the NCBI E-utilities
+	APIKey   string // optional, raises NCBI's rate limit from 3 to 10 requests a second
+	FullText bool
+	Client   *http.Client
+}
+
+type pubmedArticleSet struct {
+	Articles []pubmedArticle `xml:"PubmedArticle"`
+}
+
+type pubmedArticle struct {
+	Citation struct {
+		PMID    string `xml:"PMID"`
+		Article struct {
+			Journal struct {
+				Title   string     `xml:"Title"`
+				PubDate pubmedDate `xml:"JournalIssue>PubDate"`
+			} `xml:"Journal"`
+			Title    text           `xml:"ArticleTitle"`
+			Abstract []abstractText `xml:"Abstract>AbstractText"`
+			Authors  []struct {
+				LastName       string `xml:"LastName"`
+				Initials       string `xml:"Initials"`
+				CollectiveName string `xml:"CollectiveName"`
+			} `xml:"AuthorList>Author"`
+			ArticleDate []pubmedDate `xml:"ArticleDate"`
+		} `xml:"Article"`
+	} `xml:"MedlineCitation"`
+	ArticleIDs []struct {
+		Type string `xml:"IdType,attr"`
+		ID   string `xml:",chardata"`
+	} `xml:"PubmedData>ArticleIdList>ArticleId"`
+}
+
+// abstractText is one part of a structured abstract, e.g. METHODS.
+type abstractText struct {
+	Label string
+	Text  text
+}
+
+func (a *abstractText) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "Label" {
+			a.Label = attr.Value
+		}
+	}
+	return a.Text.UnmarshalXML(d, start)
+}
+
+type pubmedDate struct {
+	Year        string `xml:"Year"`
+	Month       string `xml:"Month"`
+	Day         string `xml:"Day"`
+	MedlineDate string `xml:"MedlineDate"`
+}
+
+var monthNumbers = map[string]string{
+	"jan": "01", "feb": "02", "mar": "03", "apr": "04", "may": "05", "jun": "06",
+	"jul": "07", "aug": "08", "sep": "09", "oct": "10", "nov": "11", "dec": "12",
+}
+
+// String formats the date as YYYY-MM-DD, or as much of it as is known.
+func (d pubmedDate) String() string {
+	if d.Year == "" {
+		return d.MedlineDate
+	}
+	month := monthNumbers[strings.ToLower(d.Month)]
+	if n, err := strconv.Atoi(d.Month); err == nil {
+		month = fmt.Sprintf("%02d", n)
+	}
+	if month == "" {
+		return d.Year
+	}
+	if day, err := strconv.Atoi(d.Day); err == nil {
+		return fmt.Sprintf("%s-%s-%02d", d.Year, month, day)
+	}
+	return d.Year + "-" + month
+}
+
+type pmcArticleSet struct {
+	Articles []pmcArticle `xml:"article"`
+}
+
+type pmcArticle struct {
+	IDs []struct {
+		Type string `xml:"pub-id-type,attr"`
+		ID   string `xml:",chardata"`
+	} `xml:"front>article-meta>article-id"`
+	Body struct {
+		Paragraphs []text        `xml:"p"`
+		Sections   []jatsSection `xml:"sec"`
+	} `xml:"body"`
+}
+
+type jatsSection struct {
+	Title      text          `xml:"title"`
+	Paragraphs []text        `xml:"p"`
+	Sections   []jatsSection `xml:"sec"`
+}
+
+func (s jatsSection) text() string {
+	var paragraphs []string
+	for _, p := range s.Paragraphs {
+		paragraphs = append(paragraphs, string(p))
+	}
+	for _, sub := range s.Sections {
+		if sub.Title != "" {
+			paragraphs = append(paragraphs, string(sub.Title))
+		}
+		paragraphs = append(paragraphs, sub.text())
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func (p PubMed) endpoint(name string, params url.Values) string {
+	base := p.BaseURL
+	if base == "" {
+		base = eutilsURL
+	}
+	params.Set("tool", "lucidsearch")
+	if p.APIKey != "" {
+		params.Set("api_key", p.APIKey)
+	}
+	return strings.TrimRight(base, "/") + "/" + name + "?" + params.Encode()
+}
+
+func (p PubMed) Search(ctx context.Context, query string, maxResults int) ([]embedstore.Result, error) {
+	ids, err := p.search(ctx, query, maxResults)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	body, err := get(ctx, p.Client, p.endpoint("efetch.fcgi", url.Values{
+		"db":      {"pubmed"},
+		"id":      {strings.Join(ids, ",")},
+		"retmode": {"xml"},
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching PubMed articles: %v", err)
+	}
+	var set pubmedArticleSet
+	if err := xml.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("error parsing PubMed articles: %v", err)
+	}
+
+	var results []embedstore.Result
+	var pmcIDs []string
+	for _, article := range set.Articles {
+		result, pmcID := pubmedResult(article)
+		if len(result.Documents) == 0 {
+			continue
+		}
+		results = append(results, result)
+		if pmcID != "" {
+			pmcIDs = append(pmcIDs, pmcID)
+		}
+	}
+
+	if p.FullText && len(pmcIDs) > 0 {
+		sections, err := p.fullText(ctx, pmcIDs)
+		if err != nil {
+			// The abstracts are still worth answering from.
+			return results, nil
+		}
+		for i := range results {
+			abstract := results[i].Documents[0].Metadata
+			for _, section := range sections[abstract["pmcid"]] {
+				for key, value := range abstract {
+					if _, ok := section.Metadata[key]; !ok {
+						section.Metadata[key] = value
+					}
+				}
+				results[i].Documents = append(results[i].Documents, section)
+			}
+		}
+	}
+	return results, nil
+}
+
+func (p PubMed) search(ctx context.Context, query string, maxResults int) ([]string, error) {
+	body, err := get(ctx, p.Client, p.endpoint("esearch.fcgi", url.Values{
+		"db":      {"pubmed"},
+		"term":    {query},
+		"retmax":  {strconv.Itoa(maxResults)},
+		"retmode": {"json"},
+		"sort":    {"relevance"},
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("error searching PubMed: %v", err)
+	}
+	var response struct {
+		Result struct {
+			IDs []string `json:"idlist"`
+		} `json:"esearchresult"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error decoding PubMed search response: %v", err)
+	}
+	return response.Result.IDs, nil
+}
+
+// pubmedResult turns an article into a result whose only document is the
+// abstract, returning its PMC ID too when it has one.
+func pubmedResult(article pubmedArticle) (embedstore.Result, string) {
+	a := article.Citation.Article
+	pmid := article.Citation.PMID
+	link := "https://pubmed.ncbi.nlm.nih.gov/" + pmid + "/"
+
+	metadata := map[string]string{
+		"source":  "pubmed",
+		"title":   string(a.Title),
+		"pmid":    pmid,
+		"journal": a.Journal.Title,
+	}
+	published := a.Journal.PubDate.String()
+	if len(a.ArticleDate) > 0 {
+		published = a.ArticleDate[0].String()
+	}
+	metadata["published"] = published
+
+	var authors []string
+	for _, author := range a.Authors {
+		if author.CollectiveName != "" {
+			authors = append(authors, author.CollectiveName)
+		} else if author.LastName != "" {
+			authors = append(authors, strings.TrimSpace(author.LastName+" "+author.Initials))
+		}
+	}
+	metadata["byline"] = strings.Join(authors, ", ")
+
+	pmcID := ""
+	for _, id := range article.ArticleIDs {
+		switch id.Type {
+		case "doi":
+			metadata["doi"] = strings.TrimSpace(id.ID)
+		case "pmc":
+			pmcID = strings.TrimSpace(id.ID)
+			metadata["pmcid"] = pmcID
+		}
+	}
+
+	var paragraphs []string
+	for _, part := range a.Abstract {
+		if part.Text == "" {
+			continue
+		}
+		if part.Label != "" {
+			paragraphs = append(paragraphs, part.Label+": "+string(part.Text))
+		} else {
+			paragraphs = append(paragraphs, string(part.Text))
+		}
+	}
+
+	result := embedstore.Result{Title: string(a.Title), Link: link}
+	if len(paragraphs) > 0 {
+		result.Documents = []embedstore.Document{{
+			PageContent: strings.Join(paragraphs, "\n\n"),
+			Metadata:    metadata,
+		}}
+	}
+	return result, pmcID
+}
+
+// fullText fetches open-access articles from PubMed Central, returning their
+// sections by PMC ID. Articles outside the open-access subset come back
+// without a body and are left out.
+func (p PubMed) fullText(ctx context.Context, pmcIDs []string) (map[string][]embedstore.Document, error) {
+	var ids []string
+	for _, id := range pmcIDs {
+		ids = append(ids, strings.TrimPrefix(id, "PMC"))
+	}
+	body, err := get(ctx, p.Client, p.endpoint("efetch.fcgi", url.Values{
+		"db": {"pmc"},
+		"id": {strings.Join(ids, ",")},
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching PMC articles: %v", err)
+	}
+	var set pmcArticleSet
+	if err := xml.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("error parsing PMC articles: %v", err)
+	}
+
+	sections := map[string][]embedstore.Document{}
+	for _, article := range set.Articles {
+		pmcID := ""
+		for _, id := range article.IDs {
+			switch id.Type {
+			case "pmc", "pmcid":
+				pmcID = "PMC" + strings.TrimPrefix(strings.TrimSpace(id.ID), "PMC")
+			}
+		}
+		if pmcID == "" {
+			continue
+		}
+
+		parts := article.Body.Sections
+		if len(article.Body.Paragraphs) > 0 {
+			parts = append([]jatsSection{{Paragraphs: article.Body.Paragraphs}}, parts...)
+		}
+		for i, part := range parts {
+			content := part.text()
+			if content == "" {
+				continue
+			}
+			sections[pmcID] = append(sections[pmcID], embedstore.Document{
+				PageContent: content,
+				Metadata: map[string]string{
+					"source":  "pmc",
+					"pmcid":   pmcID,
+					"section": strconv.Itoa(i + 1),
+					"heading": string(part.Title),
+				},
+			})
+		}
+	}
+	return sections, nil
+}
@@ -0,0 +1,160 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// pubmedServer serves the recorded E-utilities responses in testdata. fetch
+// is called with the parameters of every efetch request.
+func pubmedServer(t *testing.T, fetch func(db, ids string)) *httptest.Server {
+	t.Helper()
+	serve := func(w http.ResponseWriter, name string) {
+		body, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(body)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("tool") != "lucidsearch" || query.Get("api_key") != "test-key" {
+			t.Errorf("%s: missing tool or api_key in %s", r.URL.Path, r.URL.RawQuery)
+		}
+		switch r.URL.Path {
+		case "/esearch.fcgi":
+			if query.Get("db") != "pubmed" || query.Get("term") != "statin myopathy" || query.Get("retmax") != "3" {
+				t.Errorf("unexpected esearch parameters: %s", r.URL.RawQuery)
+			}
+			serve(w, "pubmed_esearch.json")
+		case "/efetch.fcgi":
+			fetch(query.Get("db"), query.Get("id"))
+			switch query.Get("db") {
+			case "pubmed":
+				serve(w, "pubmed_efetch.xml")
+			case "pmc":
+				serve(w, "pmc_efetch.xml")
+			default:
+				http.NotFound(w, r)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestPubMedSearch(t *testing.T) {
+	server := pubmedServer(t, func(db, ids string) {
+		if db == "pmc" {
+			t.Errorf("PMC full text fetched without FullText")
+		}
+	})
+	defer server.Close()
+
+	results, err := PubMed{BaseURL: server.URL, APIKey: "test-key"}.Search(context.Background(), "statin myopathy", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The comment without an abstract is left out.
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+
+	result := results[0]
+	if result.Title != "Statin-associated muscle symptoms in older adults: a cohort study." {
+		t.Errorf("got title %q", result.Title)
+	}
+	if result.Link != "https://pubmed.ncbi.nlm.nih.gov/38012345/" {
+		t.Errorf("got link %q", result.Link)
+	}
+	if len(result.Documents) != 1 {
+		t.Fatalf("got %d documents, want the abstract only", len(result.Documents))
+	}
+	abstract := result.Documents[0]
+	want := "BACKGROUND: Muscle symptoms are the most common reason statins are stopped.\n\nRESULTS: Symptoms were reported by 9.4% of patients aged 75 and over."
+	if abstract.PageContent != want {
+		t.Errorf("got abstract %q", abstract.PageContent)
+	}
+	for key, want := range map[string]string{
+		"source":    "pubmed",
+		"pmid":      "38012345",
+		"doi":       "10.1016/j.jacl.2023.11.004",
+		"pmcid":     "PMC10876543",
+		"journal":   "Journal of Clinical Lipidology",
+		"published": "2023-11-24",
+		"byline":    "Okafor A, Lindqvist PM, LIPID-75 Investigators",
+	} {
+		if abstract.Metadata[key] != want {
+			t.Errorf("metadata %s: got %q, want %q", key, abstract.Metadata[key], want)
+		}
+	}
+
+	// Without an electronic date, the journal issue's date is used as given.
+	if published := results[1].Documents[0].Metadata["published"]; published != "2023 Winter-Spring" {
+		t.Errorf("got published %q for a MedlineDate", published)
+	}
+}
+
+func TestPubMedFullText(t *testing.T) {
+	var pmcIDs string
+	server := pubmedServer(t, func(db, ids string) {
+		if db == "pmc" {
+			pmcIDs = ids
+		}
+	})
+	defer server.Close()
+
+	results, err := PubMed{BaseURL: server.URL, APIKey: "test-key", FullText: true}.Search(context.Background(), "statin myopathy", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pmcIDs != "10876543" {
+		t.Errorf("got PMC IDs %q, want only the open-access article without its PMC prefix", pmcIDs)
+	}
+	if len(results) != 2 || len(results[1].Documents) != 1 {
+		t.Fatal("articles outside PMC should keep only their abstract")
+	}
+
+	documents := results[0].Documents
+	if len(documents) != 3 {
+		t.Fatalf("got %d documents, want the abstract and 2 sections", len(documents))
+	}
+	introduction, methods := documents[1], documents[2]
+	if introduction.PageContent != "Statins lower cardiovascular risk at every age [1]." {
+		t.Errorf("got introduction %q", introduction.PageContent)
+	}
+	if !strings.Contains(methods.PageContent, "4,812 patients") || !strings.Contains(methods.PageContent, "Outcomes\n\nThe primary outcome") {
+		t.Errorf("got methods %q, want the nested section with its title", methods.PageContent)
+	}
+	for key, want := range map[string]string{
+		"source":  "pmc",
+		"section": "2",
+		"heading": "Methods",
+		"pmcid":   "PMC10876543",
+		// Citation details come from the abstract.
+		"pmid":    "38012345",
+		"doi":     "10.1016/j.jacl.2023.11.004",
+		"journal": "Journal of Clinical Lipidology",
+	} {
+		if methods.Metadata[key] != want {
+			t.Errorf("metadata %s: got %q, want %q", key, methods.Metadata[key], want)
+		}
+	}
+}
+
+func TestPubMedSearchFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "API rate limit exceeded", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	if _, err := (PubMed{BaseURL: server.URL}).Search(context.Background(), "statin myopathy", 3); err == nil {
+		t.Error("expected an error when esearch fails")
+	}
+}
@@ -0,0 +1,182 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	_ "time/tzdata" // the reset time zone must load on hosts without zoneinfo
+)
+
+// ErrExhausted is returned for calls beyond the day's budget.
+var ErrExhausted = errors.New("daily quota exhausted")
+
+// Google's API quotas reset at midnight Pacific time.
+const DefaultTimezone = "America/Los_Angeles"
+
+// Manager counts billed API calls per provider or model and day, refusing
+// calls beyond the configured budgets. Counts are saved to a file so
+// restarts don't reset them. It is safe for concurrent use.
+type Manager struct {
+	mu       sync.Mutex
+	budgets  map[string]int
+	location *time.Location
+	path     string
+	day      string
+	used     map[string]int
+	now      func() time.Time
+}
+
+type state struct {
+	Day  string         `json:"day"`
+	Used map[string]int `json:"used"`
+}
+
+// Usage is one key's consumption of the current day.
+type Usage struct {
+	Key       string `json:"key"`
+	Used      int    `json:"used"`
+	Budget    int    `json:"budget,omitempty"`
+	Remaining *int   `json:"remaining,omitempty"` // nil without a budget
+}
+
+// New creates a manager for the given daily budgets, keyed by provider or
+// model name; keys without a budget are only counted. Days start at midnight
+// in timezone. path, if set, is where counts are kept across restarts.
+func New(budgets map[string]int, timezone, path string) (*Manager, error) {
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("error loading quota time zone: %v", err)
+	}
+	m := &Manager{
+		budgets:  budgets,
+		location: location,
+		path:     path,
+		used:     map[string]int{},
+		now:      time.Now,
+	}
+	m.day = m.today()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			var s state
+			if err := json.Unmarshal(data, &s); err != nil {
+				return nil, fmt.Errorf("error reading quota state %s: %v", path, err)
+			}
+			if s.Day == m.day && s.Used != nil {
+				m.used = s.Used
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *Manager) today() string {
+	return m.now().In(m.location).Format(time.DateOnly)
+}
+
+// rollover starts a new day's counts once the day has changed.
+func (m *Manager) rollover() {
+	if today := m.today(); today != m.day {
+		m.day = today
+		m.used = map[string]int{}
+	}
+}
+
+// Spend records n calls against key, or returns ErrExhausted without
+// recording them if they would exceed its budget.
+func (m *Manager) Spend(key string, n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover()
+	if budget := m.budgets[key]; budget > 0 && m.used[key]+n > budget {
+		return fmt.Errorf("%s: %w", key, ErrExhausted)
+	}
+	m.used[key] += n
+	if err := m.save(); err != nil {
+		log.Printf("Error saving quota state: %v", err)
+	}
+	return nil
+}
+
+// Usage reports the day's consumption of every key that has a budget or
+// has been used, and the time the counts reset.
+func (m *Manager) Usage() ([]Usage, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollover()
+
+	keys := map[string]bool{}
+	for key := range m.budgets {
+		keys[key] = true
+	}
+	for key := range m.used {
+		keys[key] = true
+	}
+	var usage []Usage
+	for key := range keys {
+		u := Usage{Key: key, Used: m.used[key], Budget: m.budgets[key]}
+		if u.Budget > 0 {
+			remaining := max(u.Budget-u.Used, 0)
+			u.Remaining = &remaining
+		}
+		usage = append(usage, u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Key < usage[j].Key })
+
+	now := m.now().In(m.location)
+	resets := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, m.location)
+	return usage, resets
+}
+
+func (m *Manager) save() error {
+	if m.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(state{Day: m.day, Used: m.used})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".quota-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
+
+var manager *Manager
+
+// SetManager makes Spend enforce m's budgets. Without a manager every call
+// is allowed.
+func SetManager(m *Manager) {
+	manager = m
+}
+
+// Spend records n calls against key with the manager set by SetManager.
+func Spend(key string, n int) error {
+	if manager == nil {
+		return nil
+	}
+	return manager.Spend(key, n)
+}
@@ -0,0 +1,122 @@
+package quota
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSpendAcrossDays(t *testing.T) {
+	pacific, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(value string) time.Time {
+		when, err := time.ParseInLocation(time.DateTime, value, pacific)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return when
+	}
+
+	type spend struct {
+		at      string // Pacific time
+		key     string
+		n       int
+		wantErr bool
+	}
+	tests := []struct {
+		name  string
+		spend []spend
+	}{
+		{
+			name: "budget spent within a day",
+			spend: []spend{
+				{at: "2024-09-03 09:00:00", key: "google", n: 2},
+				{at: "2024-09-03 18:00:00", key: "google", n: 1},
+				{at: "2024-09-03 23:59:59", key: "google", n: 1, wantErr: true},
+			},
+		},
+		{
+			name: "a call that would exceed the budget is refused whole",
+			spend: []spend{
+				{at: "2024-09-03 09:00:00", key: "google", n: 2},
+				{at: "2024-09-03 09:01:00", key: "google", n: 2, wantErr: true},
+				{at: "2024-09-03 09:02:00", key: "google", n: 1},
+			},
+		},
+		{
+			name: "reset at midnight Pacific",
+			spend: []spend{
+				{at: "2024-09-03 20:00:00", key: "google", n: 3},
+				{at: "2024-09-03 23:59:59", key: "google", n: 1, wantErr: true},
+				{at: "2024-09-04 00:00:00", key: "google", n: 3},
+			},
+		},
+		{
+			// 17:00 Pacific is already the next day in UTC.
+			name: "not at midnight UTC",
+			spend: []spend{
+				{at: "2024-09-03 16:00:00", key: "google", n: 3},
+				{at: "2024-09-03 17:30:00", key: "google", n: 1, wantErr: true},
+			},
+		},
+		{
+			name: "keys without a budget are only counted",
+			spend: []spend{
+				{at: "2024-09-03 09:00:00", key: "embedding-001", n: 1000},
+				{at: "2024-09-03 09:00:00", key: "google", n: 3},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(map[string]int{"google": 3}, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.spend {
+				now := at(s.at)
+				m.now = func() time.Time { return now }
+				err := m.Spend(s.key, s.n)
+				if s.wantErr != errors.Is(err, ErrExhausted) {
+					t.Errorf("%s: Spend(%s, %d) = %v, want exhausted %v", s.at, s.key, s.n, err, s.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestStateAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	m, err := New(map[string]int{"google": 3}, "UTC", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Spend("google", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := New(map[string]int{"google": 3}, "UTC", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Spend("google", 1); !errors.Is(err, ErrExhausted) {
+		t.Errorf("got %v after a restart, want the day's counts kept", err)
+	}
+
+	// Counts saved on an earlier day are dropped.
+	tomorrow := time.Now().UTC().Add(24 * time.Hour)
+	restarted.now = func() time.Time { return tomorrow }
+	if err := restarted.Spend("google", 3); err != nil {
+		t.Errorf("got %v on the next day", err)
+	}
+	usage, resets := restarted.Usage()
+	if len(usage) != 1 || usage[0].Used != 3 || *usage[0].Remaining != 0 {
+		t.Errorf("got usage %+v", usage)
+	}
+	if want := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day()+1, 0, 0, 0, 0, time.UTC); !resets.Equal(want) {
+		t.Errorf("got reset at %v, want %v", resets, want)
+	}
+}
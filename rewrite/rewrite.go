@@ -0,0 +1,161 @@
+package rewrite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lucidsearch/llm"
+	"strings"
+)
+
+// Searches per query at most, the original included, to bound API usage.
+const maxQueries = 4
+
+// Kinds of rewrite.
+const (
+	Original      = "original"
+	Reformulation = "reformulation"
+	Synonyms      = "synonyms"
+	SubQuery      = "sub_query"
+)
+
+// Rewrite is one query sent to the search providers.
+type Rewrite struct {
+	Kind  string `json:"kind"`
+	Query string `json:"query"`
+}
+
+// Plan is how a query is searched: the queries to send to the providers
+// and a hypothetical answer whose embedding is used for retrieval alongside
+// the query's (HyDE).
+type Plan struct {
+	Queries      []Rewrite `json:"queries"`
+	Hypothetical string    `json:"hypothetical,omitempty"`
+}
+
+const prompt = `You turn a user's question into web search queries. Reply with only a JSON object, without markdown, with these fields:
+"reformulations": one or two concise queries a search engine would answer well, using the keywords an expert would use;
+"synonyms": the question as a query using synonyms or alternative terminology (technical and lay terms, abbreviations spelled out);
+"sub_queries": if the question has several distinct parts, one query per part, otherwise an empty list;
+"hypothetical_answer": a short paragraph that plausibly answers the question, written like a passage from a reference source. It is only used to find similar passages, so do not hedge.
+QUESTION : `
+
+// Understand asks gen for search-optimized rewrites of query. The original
+// query is always searched first, and only it is searched if the rewrites
+// can't be generated.
+func Understand(ctx context.Context, gen llm.Generator, query string) (Plan, error) {
+	plan := Plan{Queries: []Rewrite{{Kind: Original, Query: query}}}
+
+	text, err := gen.Generate(ctx, prompt+query, 0)
+	if err != nil {
+		return plan, fmt.Errorf("error rewriting query: %v", err)
+	}
+	var reply struct {
+		Reformulations     []string `json:"reformulations"`
+		Synonyms           string   `json:"synonyms"`
+		SubQueries         []string `json:"sub_queries"`
+		HypotheticalAnswer string   `json:"hypothetical_answer"`
+	}
+	if err := json.Unmarshal([]byte(stripFence(text)), &reply); err != nil {
+		return plan, fmt.Errorf("error parsing query rewrites: %v", err)
+	}
+
+	seen := map[string]bool{strings.ToLower(query): true}
+	add := func(kind, q string) {
+		q = strings.TrimSpace(q)
+		if q == "" || seen[strings.ToLower(q)] || len(plan.Queries) >= maxQueries {
+			return
+		}
+		seen[strings.ToLower(q)] = true
+		plan.Queries = append(plan.Queries, Rewrite{Kind: kind, Query: q})
+	}
+	// Sub-queries first: without them a multi-part question loses parts.
+	for _, q := range reply.SubQueries {
+		add(SubQuery, q)
+	}
+	for _, q := range reply.Reformulations {
+		add(Reformulation, q)
+	}
+	add(Synonyms, reply.Synonyms)
+	plan.Hypothetical = strings.TrimSpace(reply.HypotheticalAnswer)
+	return plan, nil
+}
+
+// stripFence removes the markdown code fence models tend to wrap JSON in
+// despite being asked not to.
+func stripFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimPrefix(text, "json")
+	return strings.TrimSpace(strings.TrimSuffix(text, "```"))
+}
+
+const decomposePrompt = `You plan the research for a complex question. Split it into the sub-questions that have to be answered first, each answerable by a single web search, in the order they should be researched; a later sub-question may depend on the answer to an earlier one. Use at most %d sub-questions, and just one, the question itself, if it is simple. Reply with only a JSON object, without markdown: {"sub_questions": [...]}
+QUESTION : `
+
+// Decompose splits a complex question into at most maxQuestions
+// sub-questions to be researched in turn, e.g. "compare FDA and EMA guidance
+// on X" into one question per agency. It returns the question itself if it can't be split.
+func Decompose(ctx context.Context, gen llm.Generator, question string, maxQuestions int) ([]string, error) {
+	text, err := gen.Generate(ctx, fmt.Sprintf(decomposePrompt, maxQuestions)+question, 0)
+	if err != nil {
+		return []string{question}, fmt.Errorf("error decomposing question: %v", err)
+	}
+	var reply struct {
+		SubQuestions []string `json:"sub_questions"`
+	}
+	if err := json.Unmarshal([]byte(stripFence(text)), &reply); err != nil {
+		return []string{question}, fmt.Errorf("error parsing sub-questions: %v", err)
+	}
+
+	var questions []string
+	for _, q := range reply.SubQuestions {
+		if q = strings.TrimSpace(q); q != "" && len(questions) < maxQuestions {
+			questions = append(questions, q)
+		}
+	}
+	if len(questions) == 0 {
+		return []string{question}, nil
+	}
+	return questions, nil
+}
+
+const resolvePrompt = `Rewrite the next research question so it can be searched on its own, replacing references to earlier findings (such as "that drug" or "the agency") with what the findings say they are. Reply with only the rewritten question.
+FINDINGS SO FAR : %s
+NEXT QUESTION : %s`
+
+// Resolve makes a sub-question that depends on earlier findings
+// self-contained, so it can be searched. It returns the question unchanged
+// if it can't be rewritten.
+func Resolve(ctx context.Context, gen llm.Generator, question, findings string) (string, error) {
+	text, err := gen.Generate(ctx, fmt.Sprintf(resolvePrompt, findings, question), 0)
+	if err != nil {
+		return question, fmt.Errorf("error resolving question: %v", err)
+	}
+	if text = strings.TrimSpace(stripFence(text)); text == "" {
+		return question, nil
+	}
+	return text, nil
+}
+
+const condensePrompt = `Rewrite the user's follow-up question so it can be understood and searched without the conversation, replacing references to it (such as "it", "that", "what about in children?") with what they refer to. If the question already stands on its own, repeat it unchanged. Reply with only the question.
+CONVERSATION :
+%s
+FOLLOW-UP QUESTION : %s`
+
+// Condense makes a follow-up question in a conversation standalone, so it
+// can be searched and answered like a first question. It returns the
+// question unchanged if it can't be rewritten.
+func Condense(ctx context.Context, gen llm.Generator, history, question string) (string, error) {
+	text, err := gen.Generate(ctx, fmt.Sprintf(condensePrompt, history, question), 0)
+	if err != nil {
+		return question, fmt.Errorf("error condensing question: %v", err)
+	}
+	if text = strings.TrimSpace(stripFence(text)); text == "" {
+		return question, nil
+	}
+	return text, nil
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"lucidsearch/dedupe"
+	"lucidsearch/embedstore"
+	"lucidsearch/extract"
+	"lucidsearch/llm"
+	"lucidsearch/profile"
+	"lucidsearch/provider"
+	"lucidsearch/quota"
+	"lucidsearch/rewrite"
+	"strconv"
+	"sync"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// Chunks retrieved per question. Twice as many are fetched so trust
+// weighting can reorder them.
+const retrievalLimit = 10
+
+// Score boost for chunks retrieved earlier in a conversation: a follow-up is
+// usually about what was just discussed.
+const sessionBoost = 1.1
+
+const answerInstruction = `You are a helpful AI assistant that helps users answer queries using the provided context. If you cant frame an answer from the context given, copy paste directly from context rather than making up an answer. Please provide a detailed answer to the query below only using the context provided. Include in-text citations using the citation number given with each paragraph, like this [1], for each fact or statement at the end of the sentence.`
+
+// pipeline searches, scrapes, embeds and retrieves for one request. Results
+// are deduplicated across all of its searches, so a page found again in a
+// later round isn't scraped twice.
+type pipeline struct {
+	ctx      context.Context
+	client   *genai.Client
+	profile  *profile.Profile
+	tedTalks []extract.TEDTalk
+	merger   *dedupe.Merger
+	// sessionChunks are the IDs of the chunks retrieved earlier in the
+	// conversation, if any.
+	sessionChunks []string
+
+	mu        sync.Mutex
+	sources   []SourceStatus
+	retrieved []string
+}
+
+func newPipeline(ctx context.Context, client *genai.Client, searchProfile *profile.Profile) *pipeline {
+	tedTalks, _ := LoadTEDTalks("new_op.json")
+	return &pipeline{
+		ctx:      ctx,
+		client:   client,
+		profile:  searchProfile,
+		tedTalks: tedTalks,
+		// Providers often find the same page, sometimes under different links.
+		merger: dedupe.NewMerger(),
+	}
+}
+
+func (p *pipeline) generator() llm.Generator {
+	return llm.Gemini{Client: p.client, Model: generationModel}
+}
+
+// plan rewrites query for search if asked to, or searches it as it is.
+func (p *pipeline) plan(query string, rewriteQuery bool) rewrite.Plan {
+	if !rewriteQuery {
+		return rewrite.Plan{Queries: []rewrite.Rewrite{{Kind: rewrite.Original, Query: query}}}
+	}
+	plan, err := rewrite.Understand(p.ctx, p.generator(), query)
+	if err != nil {
+		log.Printf("Searching the original query only: %v", err)
+	}
+	for _, rw := range plan.Queries {
+		log.Printf("Query rewrite (%s): %s", rw.Kind, rw.Query)
+	}
+	if plan.Hypothetical != "" {
+		log.Printf("Hypothetical answer: %s", plan.Hypothetical)
+	}
+	return plan
+}
+
+// gather runs the queries across the profile's providers, then scrapes and
+// embeds what they find. It returns the number of new sources checked.
+func (p *pipeline) gather(queries []rewrite.Rewrite) int {
+	policy := p.profile.Policy
+
+	// Channel to receive search results and a wait group till evry gets bback
+	resultsCh := make(chan embedstore.Result)
+	var wg sync.WaitGroup
+
+	for _, ref := range p.profile.Providers {
+		prov, _ := provider.Get(ref.Name)
+		// The provider's results are shared between the queries.
+		maxResults := (ref.MaxResults + len(queries) - 1) / len(queries)
+		for _, rw := range queries {
+			wg.Add(1)
+			go func(name string, prov provider.Provider, query string) {
+				defer wg.Done()
+				results, err := prov.Search(p.ctx, query, maxResults)
+				if err != nil {
+					log.Printf("Error searching %s: %v", name, err)
+					return
+				}
+				for _, result := range results {
+					resultsCh <- result
+				}
+			}(ref.Name, prov, rw.Query)
+		}
+	}
+
+	// end recv
+	go func() {
+		wg.Wait()
+		close(resultsCh)
+	}()
+
+	p.mu.Lock()
+	before := len(p.sources)
+	p.mu.Unlock()
+
+	// Process each result from the search results channel
+	var processWg sync.WaitGroup
+	for result := range resultsCh {
+		result, ok := p.merger.Add(result)
+		if !ok {
+			log.Printf("Skipping duplicate result: %s", result.Link)
+			continue
+		}
+		if !policy.Allowed(result.Link) {
+			log.Printf("Skipping result excluded by the trust policy: %s", result.Link)
+			p.addSource(SourceStatus{Title: result.Title, Link: result.Link, Status: sourceBlockedByPolicy, Trust: policy.Tier(result.Link)})
+			continue
+		}
+		processWg.Add(1)
+		go func(result embedstore.Result) {
+			// Scrape the content from the search result link
+			defer processWg.Done()
+			documents, err := extract.Scrape(result, p.tedTalks)
+			status := sourceStatus(result, documents, err)
+			status.Trust = policy.Tier(result.Link)
+			if status.Status == sourceOK {
+				if original := p.merger.AddContent(result.Link, documents); original != "" {
+					log.Printf("Skipping %s, same content as %s", result.Link, original)
+					status.Status = sourceDuplicate
+					status.DuplicateOf = original
+					documents = nil
+				}
+			}
+			p.addSource(status)
+			ingestDocuments(p.ctx, p.client, result, documents)
+		}(result)
+	}
+	processWg.Wait()
+	log.Printf("Total embeddings: %d", totalChunks)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.sources) - before
+}
+
+func (p *pipeline) addSource(status SourceStatus) {
+	p.mu.Lock()
+	p.sources = append(p.sources, status)
+	p.mu.Unlock()
+}
+
+// retrieve finds the stored chunks closest to query and, when there is one,
+// to a hypothetical answer (HyDE), ranked by trust. In a conversation, the
+// chunks retrieved for earlier turns are searched too and rank a little
+// higher.
+func (p *pipeline) retrieve(query, hypothetical string) ([]embedstore.ChunkData, error) {
+	queryEmbedding, err := embedstore.EmbedQuery(p.ctx, p.client, query, "embedding-001")
+	if err != nil {
+		return nil, fmt.Errorf("error generating query embedding: %w", err)
+	}
+
+	scoreThreshold := p.profile.MinScore
+	points, err := embedstore.SearchQdrant(queryEmbedding, 2*retrievalLimit, scoreThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("error searching Qdrant: %w", err)
+	}
+	if hypothetical != "" {
+		// HyDE: passages like a plausible answer are often closer to the
+		// real answer than the question is.
+		hydeEmbedding, err := embedstore.EmbedPassage(p.ctx, p.client, hypothetical, "embedding-001")
+		if err == nil {
+			var hydePoints []embedstore.ScoredPoint
+			if hydePoints, err = embedstore.SearchQdrant(hydeEmbedding, 2*retrievalLimit, scoreThreshold); err == nil {
+				points = embedstore.MergePoints(points, hydePoints)
+			}
+		}
+		if err != nil {
+			log.Printf("Searching without the hypothetical answer: %v", err)
+		}
+	}
+	if len(p.sessionChunks) > 0 {
+		sessionPoints, err := embedstore.SearchQdrantIDs(queryEmbedding, p.sessionChunks, retrievalLimit, scoreThreshold)
+		if err != nil {
+			log.Printf("Searching without the conversation's earlier chunks: %v", err)
+		}
+		for i := range sessionPoints {
+			sessionPoints[i].Score *= sessionBoost
+		}
+		points = embedstore.MergePoints(points, sessionPoints)
+	}
+
+	chunks, err := embedstore.GetChunks(points)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving chunks: %w", err)
+	}
+	chunks = rankByTrust(chunks, p.profile.Policy, retrievalLimit)
+
+	p.mu.Lock()
+	for _, chunk := range chunks {
+		p.retrieved = append(p.retrieved, chunk.ID)
+	}
+	p.mu.Unlock()
+	return chunks, nil
+}
+
+// ask answers query from one round of search, scrape, embed and retrieve.
+// emit, if set, gets the answer as it is written.
+func (p *pipeline) ask(query string, rewriteQuery bool, emit func(string)) (string, []Citation, rewrite.Plan, error) {
+	plan := p.plan(query, rewriteQuery)
+	p.gather(plan.Queries)
+
+	chunks, err := p.retrieve(query, plan.Hypothetical)
+	if err != nil {
+		return "", nil, plan, err
+	}
+	citations := newCitationContext(p.profile)
+	context, passages, _ := citations.add(chunks)
+	llmquery := "INSTRUCTION : " + citations.instruction() + ". QUERY : " + query + ". CONTEXT : " + context + "."
+	answer, cited := citations.streamAnswer(p.ctx, p.generator(), llmquery, passages, emit)
+	return answer, cited, plan, nil
+}
+
+// citationContext numbers the sources of retrieved chunks, one number per
+// link and location, and keeps the numbers stable across retrieval rounds.
+type citationContext struct {
+	profile   *profile.Profile
+	citations []Citation
+	numbers   map[string]int
+	chunks    []embedstore.ChunkData
+	seen      map[string]bool
+}
+
+func newCitationContext(searchProfile *profile.Profile) *citationContext {
+	return &citationContext{profile: searchProfile, numbers: map[string]int{}, seen: map[string]bool{}}
+}
+
+// add numbers the chunks and formats them as context for the model, and as
+// plain passages to fall back on. It returns their citation numbers too.
+func (c *citationContext) add(chunks []embedstore.ChunkData) (context, passages string, numbers []int) {
+	for _, chunk := range chunks {
+		link, location := chunkLink(chunk), chunkLocation(chunk)
+		number, ok := c.numbers[link+" "+location]
+		if !ok {
+			number = len(c.citations) + 1
+			c.numbers[link+" "+location] = number
+			c.citations = append(c.citations, Citation{Number: number, Title: chunk.Title, Link: link, Location: location, Trust: c.profile.Policy.Tier(chunk.Link)})
+		}
+		if !c.seen[chunk.Text] {
+			c.seen[chunk.Text] = true
+			c.chunks = append(c.chunks, chunk)
+		}
+		numbers = append(numbers, number)
+	}
+	context, passages = c.format(chunks)
+	return context, passages, numbers
+}
+
+// all formats every chunk added so far, once each.
+func (c *citationContext) all() (context, passages string) {
+	return c.format(c.chunks)
+}
+
+func (c *citationContext) format(chunks []embedstore.ChunkData) (context, passages string) {
+	policy := c.profile.Policy
+	for _, chunk := range chunks {
+		link, location := chunkLink(chunk), chunkLocation(chunk)
+		number := strconv.Itoa(c.numbers[link+" "+location])
+		context += "Citation number -> [" + number + "] . "
+		context += "Title of the website where the following paragraph was obtained from -> " + chunk.Title + ". Link of the website -> " + link + " . "
+		if location != "" {
+			context += "Location in the source -> " + location + " . "
+		}
+		for _, field := range citationFields {
+			if value := chunk.Metadata[field.key]; value != "" {
+				context += field.label + " -> " + value + " . "
+			}
+		}
+		context += "Trust tier of the source -> " + policy.Tier(chunk.Link) + " . "
+		context += "Paragraph -> " + chunk.Text + " . End of that paragraph.\n Starting new paragraph :  \n"
+		passages += "[" + number + "] " + chunk.Text + "\n\n"
+	}
+	return context, passages
+}
+
+// instruction is the answer instruction for the profile.
+func (c *citationContext) instruction() string {
+	instruction := answerInstruction
+	if c.profile.Instructions != "" {
+		instruction += " " + c.profile.Instructions
+	}
+	return instruction + " " + c.profile.CitationInstruction()
+}
+
+// answer has the model answer query from context, or abstains when the
+// retrieved chunks come from too few sources. Without a generation budget
+// the passages are returned instead. The citations are dropped when there is
+// no answer to cite them.
+func (c *citationContext) answer(ctx context.Context, gen llm.Generator, prompt, passages string) (string, []Citation) {
+	return c.streamAnswer(ctx, gen, prompt, passages, nil)
+}
+
+// streamAnswer is answer, also handing the answer to emit, if set, as it is
+// written.
+func (c *citationContext) streamAnswer(ctx context.Context, gen llm.Generator, prompt, passages string, emit func(string)) (string, []Citation) {
+	stream := emit != nil
+	if !stream {
+		emit = func(string) {}
+	}
+	if n := sourceCount(c.chunks); n < c.profile.MinSources {
+		// Not enough material to answer reliably; say so instead of
+		// letting the model guess.
+		log.Printf("Abstaining: %d sources above score %.2f, profile %s needs %d", n, c.profile.MinScore, c.profile.Name, c.profile.MinSources)
+		emit(abstention)
+		return abstention, nil
+	}
+	var (
+		answer  string
+		err     error
+		emitted bool
+	)
+	if streamer, ok := gen.(llm.Streamer); ok && stream {
+		answer, err = streamer.Stream(ctx, prompt, 0, func(text string) {
+			emitted = true
+			emit(text)
+		})
+	} else {
+		answer, err = gen.Generate(ctx, prompt, 0)
+	}
+	if err != nil && emitted {
+		// Part of the answer is already out; end it there.
+		log.Printf("Error generating the rest of the answer: %v", err)
+		return answer + "\n---\n", c.citations
+	}
+	if errors.Is(err, quota.ErrExhausted) {
+		// Without a generation budget, answer with the passages the model
+		// would have been given.
+		log.Printf("Answering from the knowledge base only: %v", err)
+		emit(knowledgeBaseOnly + passages)
+		return knowledgeBaseOnly + passages, c.citations
+	}
+	if err != nil {
+		log.Printf("Error generating answer: %v", err)
+		emit(answerFailed)
+		return answerFailed, nil
+	}
+	if !emitted {
+		emit(answer)
+	}
+	return answer + "\n---\n", c.citations
+}
@@ -0,0 +1,111 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for sessions that don't exist or have expired.
+var ErrNotFound = errors.New("session not found")
+
+// Bounds on what a session keeps, so long conversations don't grow without
+// limit: turns stored, turns shown when condensing a follow-up, characters of
+// each earlier answer shown, and retrieved chunks remembered.
+const (
+	maxTurns        = 20
+	historyTurns    = 5
+	maxAnswerLength = 1000
+	maxChunks       = 50
+)
+
+// Turn is one question of a conversation and its answer. Standalone is the
+// question as it was searched, when it had to be rewritten to stand on its
+// own.
+type Turn struct {
+	Query      string    `json:"query"`
+	Standalone string    `json:"standalone,omitempty"`
+	Answer     string    `json:"answer"`
+	Time       time.Time `json:"time"`
+}
+
+// Session is a conversation: its turns and the IDs of the chunks retrieved
+// for them, most recent last.
+type Session struct {
+	ID      string    `json:"id"`
+	Turns   []Turn    `json:"turns"`
+	Chunks  []string  `json:"chunks,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// New starts a session with a random ID.
+func New() (*Session, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &Session{ID: hex.EncodeToString(b), Created: now, Updated: now}, nil
+}
+
+// validID reports whether id looks like one New made, so IDs from requests
+// can't name arbitrary files.
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// AddTurn records a turn and the chunks retrieved for it.
+func (s *Session) AddTurn(turn Turn, chunks []string) {
+	if turn.Time.IsZero() {
+		turn.Time = time.Now()
+	}
+	s.Turns = append(s.Turns, turn)
+	if len(s.Turns) > maxTurns {
+		s.Turns = s.Turns[len(s.Turns)-maxTurns:]
+	}
+
+	seen := map[string]bool{}
+	for _, id := range chunks {
+		seen[id] = true
+	}
+	// Chunks retrieved again move to the end.
+	var kept []string
+	for _, id := range s.Chunks {
+		if !seen[id] {
+			kept = append(kept, id)
+		}
+	}
+	s.Chunks = append(kept, chunks...)
+	if len(s.Chunks) > maxChunks {
+		s.Chunks = s.Chunks[len(s.Chunks)-maxChunks:]
+	}
+	s.Updated = turn.Time
+}
+
+// History formats the last turns for a prompt.
+func (s *Session) History() string {
+	turns := s.Turns
+	if len(turns) > historyTurns {
+		turns = turns[len(turns)-historyTurns:]
+	}
+	var sb strings.Builder
+	for _, turn := range turns {
+		query := turn.Query
+		if turn.Standalone != "" {
+			query = turn.Standalone
+		}
+		answer := turn.Answer
+		if len(answer) > maxAnswerLength {
+			answer = answer[:maxAnswerLength] + "..."
+		}
+		sb.WriteString("USER : " + query + "\nASSISTANT : " + answer + "\n")
+	}
+	return sb.String()
+}
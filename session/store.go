@@ -0,0 +1,178 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store keeps sessions between requests. Sessions not updated within the
+// store's TTL expire.
+type Store interface {
+	// Get returns the session with the given ID, or ErrNotFound.
+	Get(id string) (*Session, error)
+	Save(s *Session) error
+	Delete(id string) error
+}
+
+// Expired sessions are removed at most this often; until then Get ignores
+// them.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps sessions in memory, so they are lost on restart. It is
+// safe for concurrent use.
+type MemoryStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	sessions  map[string]Session
+	lastSweep time.Time
+}
+
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{ttl: ttl, sessions: map[string]Session{}, lastSweep: time.Now()}
+}
+
+func (m *MemoryStore) Get(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || time.Since(s.Updated) > m.ttl {
+		return nil, ErrNotFound
+	}
+	// A copy, so the caller's changes only count once saved.
+	s.Turns = append([]Turn(nil), s.Turns...)
+	s.Chunks = append([]string(nil), s.Chunks...)
+	return &s, nil
+}
+
+func (m *MemoryStore) Save(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Since(m.lastSweep) > sweepInterval {
+		for id, other := range m.sessions {
+			if time.Since(other.Updated) > m.ttl {
+				delete(m.sessions, id)
+			}
+		}
+		m.lastSweep = time.Now()
+	}
+	m.sessions[s.ID] = *s
+	return nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// FileStore keeps each session in a JSON file in a directory, so sessions
+// survive restarts and can be shared by instances on the same disk.
+type FileStore struct {
+	dir string
+	ttl time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewFileStore(dir string, ttl time.Duration) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating session directory: %v", err)
+	}
+	f := &FileStore{dir: dir, ttl: ttl, lastSweep: time.Now()}
+	f.sweep()
+	return f, nil
+}
+
+func (f *FileStore) path(id string) string {
+	return filepath.Join(f.dir, id+".json")
+}
+
+func (f *FileStore) Get(id string) (*Session, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(f.path(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("error reading session %s: %v", id, err)
+	}
+	if time.Since(s.Updated) > f.ttl {
+		os.Remove(f.path(id))
+		return nil, ErrNotFound
+	}
+	return &s, nil
+}
+
+func (f *FileStore) Save(s *Session) error {
+	if !validID(s.ID) {
+		return fmt.Errorf("invalid session ID %q", s.ID)
+	}
+	f.mu.Lock()
+	if time.Since(f.lastSweep) > sweepInterval {
+		go f.sweep()
+		f.lastSweep = time.Now()
+	}
+	f.mu.Unlock()
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(s.ID))
+}
+
+func (f *FileStore) Delete(id string) error {
+	if !validID(id) {
+		return nil
+	}
+	if err := os.Remove(f.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// sweep removes the files of expired sessions, going by modification time
+// since every save rewrites the file.
+func (f *FileStore) sweep() {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err == nil && time.Since(info.ModTime()) > f.ttl {
+			os.Remove(filepath.Join(f.dir, entry.Name()))
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package wiki
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"lucidsearch/embedstore"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Page is the latest revision of an article.
+type Page struct {
+	Title      string
+	RevisionID int64
+	Timestamp  string
+	Text       string
+}
+
+type dumpPage struct {
+	Title    string    `xml:"title"`
+	NS       int       `xml:"ns"`
+	Redirect *struct{} `xml:"redirect"`
+	Revision struct {
+		ID        int64  `xml:"id"`
+		Timestamp string `xml:"timestamp"`
+		Text      string `xml:"text"`
+	} `xml:"revision"`
+}
+
+// ReadDump streams the articles of a MediaWiki XML export (such as the
+// pages-articles dumps of Wikipedia) to fn, skipping redirects and pages
+// outside the main namespace. fn also gets the wiki's base URL, the
+// <siteinfo><base> link to its main page. ReadDump stops at the first error
+// fn returns.
+func ReadDump(r io.Reader, fn func(base string, page Page) error) error {
+	decoder := xml.NewDecoder(r)
+	base := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading dump: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "base":
+			if err := decoder.DecodeElement(&base, &start); err != nil {
+				return fmt.Errorf("error reading dump: %v", err)
+			}
+		case "page":
+			var page dumpPage
+			if err := decoder.DecodeElement(&page, &start); err != nil {
+				return fmt.Errorf("error reading dump: %v", err)
+			}
+			if page.NS != 0 || page.Redirect != nil {
+				continue
+			}
+			if err := fn(base, Page{
+				Title:      page.Title,
+				RevisionID: page.Revision.ID,
+				Timestamp:  page.Revision.Timestamp,
+				Text:       page.Revision.Text,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Permalink links to the exact revision of a page, so citations stay
+// reproducible as the article changes. indexURL is the wiki's index.php.
+func Permalink(indexURL, title string, revisionID int64) string {
+	params := url.Values{
+		"title": {strings.ReplaceAll(title, " ", "_")},
+		"oldid": {strconv.FormatInt(revisionID, 10)},
+	}
+	return indexURL + "?" + params.Encode()
+}
+
+// PageLink links to the current revision of a page. It names the page
+// whatever its revision, so it is the source ID its chunks are stored under.
+func PageLink(indexURL, title string) string {
+	params := url.Values{"title": {strings.ReplaceAll(title, " ", "_")}}
+	return indexURL + "?" + params.Encode()
+}
+
+// IndexURL guesses the index.php of a wiki from a link to one of its
+// articles, e.g. https://en.wikipedia.org/wiki/Main_Page.
+func IndexURL(articleURL string) string {
+	u, err := url.Parse(articleURL)
+	if err != nil || u.Host == "" {
+		return "https://en.wikipedia.org/w/index.php"
+	}
+	return u.Scheme + "://" + u.Host + "/w/index.php"
+}
+
+// Result turns a page into a search result with one document per section.
+// It is cited by its permalink but stored under its PageLink, so a newer
+// revision replaces the chunks of the old one.
+func Result(page Page, indexURL, source string) embedstore.Result {
+	link := Permalink(indexURL, page.Title, page.RevisionID)
+	var documents []embedstore.Document
+	for i, section := range Sections(page.Text) {
+		documents = append(documents, embedstore.Document{
+			PageContent: section.Text,
+			Metadata: map[string]string{
+				"source":      source,
+				"title":       page.Title,
+				"revision_id": strconv.FormatInt(page.RevisionID, 10),
+				"published":   page.Timestamp,
+				"section":     strconv.Itoa(i + 1),
+				"heading":     section.Heading,
+			},
+		})
+	}
+	return embedstore.Result{Title: page.Title, Link: link, ID: PageLink(indexURL, page.Title), Documents: documents}
+}
@@ -0,0 +1,163 @@
+package wiki
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Section is a top-level (== Heading ==) section of an article. The lead,
+// before the first heading, has no heading.
+type Section struct {
+	Heading string
+	Text    string
+}
+
+// Sections that are lists of links or references rather than content.
+var skippedSections = map[string]bool{
+	"references": true, "notes": true, "footnotes": true, "citations": true, "sources": true,
+	"bibliography": true, "see also": true, "external links": true, "further reading": true,
+}
+
+var (
+	comment      = regexp.MustCompile(`(?s)<!--.*?-->`)
+	ref          = regexp.MustCompile(`(?is)<ref[^>]*/>|<ref[^>]*>.*?</ref>`)
+	noText       = regexp.MustCompile(`(?is)<(gallery|math|score|syntaxhighlight|timeline)[^>]*>.*?</(gallery|math|score|syntaxhighlight|timeline)>`)
+	tag          = regexp.MustCompile(`<[^>]+>`)
+	externalLink = regexp.MustCompile(`\[(?:https?:)?//[^\s\]]+\s*([^\]]*)\]`)
+	emphasis     = regexp.MustCompile(`'{2,}`)
+	heading      = regexp.MustCompile(`^(={2,6})\s*(.*?)\s*={2,6}\s*$`)
+	listMarker   = regexp.MustCompile(`^[*#:;]+\s*`)
+	magicWord    = regexp.MustCompile(`__[A-Z]+__`)
+)
+
+// Sections splits wikitext into plain-text sections, leaving out templates,
+// tables, references, files and categories.
+func Sections(wikitext string) []Section {
+	text := comment.ReplaceAllString(wikitext, "")
+	text = ref.ReplaceAllString(text, "")
+	text = noText.ReplaceAllString(text, "")
+	text = removeNested(text, "{{", "}}")
+	text = removeNested(text, "{|", "|}")
+	text = replaceLinks(text)
+	text = externalLink.ReplaceAllString(text, "$1")
+	text = tag.ReplaceAllString(text, "")
+	text = emphasis.ReplaceAllString(text, "")
+	text = magicWord.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	var sections []Section
+	current := Section{}
+	var paragraphs []string
+	var paragraph []string
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			paragraphs = append(paragraphs, strings.Join(paragraph, " "))
+			paragraph = nil
+		}
+	}
+	flushSection := func() {
+		flushParagraph()
+		current.Text = strings.Join(paragraphs, "\n\n")
+		if current.Text != "" && !skippedSections[strings.ToLower(current.Heading)] {
+			sections = append(sections, current)
+		}
+		paragraphs = nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if match := heading.FindStringSubmatch(line); match != nil {
+			if len(match[1]) == 2 {
+				flushSection()
+				current = Section{Heading: match[2]}
+			} else {
+				// Subsection headings stay in the section as their own
+				// paragraph.
+				flushParagraph()
+				paragraphs = append(paragraphs, match[2])
+			}
+			continue
+		}
+		line = listMarker.ReplaceAllString(line, "")
+		if line == "" {
+			flushParagraph()
+			continue
+		}
+		paragraph = append(paragraph, strings.Join(strings.Fields(line), " "))
+	}
+	flushSection()
+	return sections
+}
+
+// removeNested drops everything between open and close, which may nest, as
+// templates do.
+func removeNested(text, open, close string) string {
+	var sb strings.Builder
+	depth := 0
+	for i := 0; i < len(text); {
+		switch {
+		case strings.HasPrefix(text[i:], open):
+			depth++
+			i += len(open)
+		case depth > 0 && strings.HasPrefix(text[i:], close):
+			depth--
+			i += len(close)
+		default:
+			if depth == 0 {
+				sb.WriteByte(text[i])
+			}
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// replaceLinks turns [[Target|label]] into its label and [[Target]] into the
+// target, and drops file, image and category links, whose captions can
+// contain links themselves.
+func replaceLinks(text string) string {
+	var sb strings.Builder
+	for {
+		start := strings.Index(text, "[[")
+		if start < 0 {
+			sb.WriteString(text)
+			return sb.String()
+		}
+		sb.WriteString(text[:start])
+
+		depth, end := 0, -1
+		for i := start; i+1 < len(text); i++ {
+			if text[i:i+2] == "[[" {
+				depth++
+				i++
+			} else if text[i:i+2] == "]]" {
+				depth--
+				i++
+				if depth == 0 {
+					end = i + 1
+					break
+				}
+			}
+		}
+		if end < 0 {
+			// Unbalanced; keep the rest as it is.
+			sb.WriteString(text[start:])
+			return sb.String()
+		}
+
+		inner := text[start+2 : end-2]
+		target, label, hasLabel := strings.Cut(inner, "|")
+		namespace, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(target)), ":")
+		switch namespace {
+		case "file", "image", "category", "media":
+		default:
+			if hasLabel {
+				sb.WriteString(replaceLinks(label))
+			} else {
+				sb.WriteString(target)
+			}
+		}
+		text = text[end:]
+	}
+}